@@ -0,0 +1,242 @@
+package mode
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/radekg/terraform-provisioner-ansible/v2/types"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshTarget is a host Ansible connects to.
+type sshTarget struct {
+	alias string
+	host  string
+	port  int
+	// the connection credentials apply to the compute resource host only:
+	credentials bool
+}
+
+func (t *sshTarget) knownHostsAddress() string {
+	return knownHostsAddress(t.host, t.port)
+}
+
+type ansibleInventoryList struct {
+	Meta struct {
+		HostVars map[string]map[string]interface{} `json:"hostvars"`
+	} `json:"_meta"`
+}
+
+type ansibleInventoryGroup struct {
+	Hosts []string `json:"hosts"`
+}
+
+// resolveTargets returns the hosts Ansible connects to, other than the compute resource host:
+// plays.hosts of a null_resource and the hosts of every inventory_file.
+func (v *LocalMode) resolveTargets(plays []*types.Play) ([]*sshTarget, error) {
+	targets := make([]*sshTarget, 0)
+	seen := make(map[string]bool)
+	add := func(candidates []*sshTarget) {
+		for _, candidate := range candidates {
+			if candidate.host == v.connInfo.Host && candidate.port == v.connInfo.Port {
+				continue
+			}
+			if !seen[candidate.knownHostsAddress()] {
+				seen[candidate.knownHostsAddress()] = true
+				targets = append(targets, candidate)
+			}
+		}
+	}
+	for _, play := range plays {
+		if !play.Enabled() {
+			continue
+		}
+		if play.InventoryFile() != "" {
+			output, err := exec.Command("ansible-inventory", "-i", play.InventoryFile(), "--list").Output()
+			if err != nil {
+				return nil, fmt.Errorf("failed listing hosts of inventory '%s': %v", play.InventoryFile(), err)
+			}
+			inventoryTargets, err := parseAnsibleInventoryList(output, v.connInfo.Port)
+			if err != nil {
+				return nil, fmt.Errorf("failed reading hosts of inventory '%s': %v", play.InventoryFile(), err)
+			}
+			add(inventoryTargets)
+		} else if !v.ComputeResource() {
+			for _, host := range play.Hosts() {
+				if host != "" {
+					add([]*sshTarget{&sshTarget{alias: host, host: rawHost(host), port: v.connInfo.Port}})
+				}
+			}
+		}
+	}
+	return targets, nil
+}
+
+// parseAnsibleInventoryList reads SSH targets from the ansible-inventory --list output.
+// Hosts using the local connection are skipped.
+func parseAnsibleInventoryList(data []byte, defaultPort int) ([]*sshTarget, error) {
+	list := &ansibleInventoryList{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, err
+	}
+	groups := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, err
+	}
+	aliases := make([]string, 0)
+	seen := make(map[string]bool)
+	addAlias := func(alias string) {
+		if !seen[alias] {
+			seen[alias] = true
+			aliases = append(aliases, alias)
+		}
+	}
+	for name, raw := range groups {
+		if name == "_meta" {
+			continue
+		}
+		group := &ansibleInventoryGroup{}
+		if err := json.Unmarshal(raw, group); err != nil {
+			return nil, err
+		}
+		for _, alias := range group.Hosts {
+			addAlias(alias)
+		}
+	}
+	for alias := range list.Meta.HostVars {
+		addAlias(alias)
+	}
+	sort.Strings(aliases)
+
+	targets := make([]*sshTarget, 0)
+	for _, alias := range aliases {
+		hostVars := list.Meta.HostVars[alias]
+		if connection, ok := hostVars["ansible_connection"].(string); ok && connection == "local" {
+			continue
+		}
+		target := &sshTarget{alias: alias, host: rawHost(alias), port: defaultPort}
+		if host, ok := hostVars["ansible_host"].(string); ok && host != "" {
+			target.host = rawHost(host)
+		}
+		switch port := hostVars["ansible_port"].(type) {
+		case float64:
+			target.port = int(port)
+		case string:
+			if val, err := strconv.Atoi(port); err == nil {
+				target.port = val
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// pinnedKnownHosts returns the known hosts addresses covered by the pinned host keys.
+func pinnedKnownHosts(hostKeys []string) map[string]bool {
+	pinned := make(map[string]bool)
+	for _, line := range hostKeys {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		for _, address := range strings.Split(fields[0], ",") {
+			pinned[address] = true
+		}
+	}
+	return pinned
+}
+
+// collectKnownHosts returns known_hosts lines for the targets. Hosts with pinned keys are not contacted.
+// Remaining keys are collected with ssh-keyscan on the bastion when a bastion client is given,
+// otherwise directly.
+func collectKnownHosts(o terraform.UIOutput,
+	targets []*sshTarget,
+	bastionClient *ssh.Client,
+	ansibleSSHSettings *types.AnsibleSSHSettings) ([]string, error) {
+
+	knownHosts := make([]string, 0)
+	knownHosts = append(knownHosts, ansibleSSHSettings.HostKeys()...)
+	pinned := pinnedKnownHosts(ansibleSSHSettings.HostKeys())
+
+	for _, target := range targets {
+		if pinned[target.knownHostsAddress()] {
+			o.Output(fmt.Sprintf("using pinned host key for '%s'", target.alias))
+			continue
+		}
+		if bastionClient != nil {
+			o.Output(fmt.Sprintf("host key for '%s' not given, executing ssh-keyscan on bastion", target.alias))
+			targetKnownHosts, err := newBastionKeyScan(o,
+				bastionClient,
+				target.host,
+				target.port,
+				ansibleSSHSettings.HostKeyCollectionSeconds()).scan()
+			if err != nil {
+				return nil, err
+			}
+			knownHosts = append(knownHosts, targetKnownHosts)
+			continue
+		}
+		o.Output(fmt.Sprintf("host key for '%s' not given, fetching from %s", target.alias, sshAddress(target.host, target.port)))
+		hostKey, err := fetchHostKeyWithRetry(o, target.host, target.port,
+			ansibleSSHSettings.ConnectTimeoutSeconds(),
+			ansibleSSHSettings.HostKeyCollectionSeconds())
+		if err != nil {
+			return nil, err
+		}
+		knownHosts = append(knownHosts, fmt.Sprintf("%s %s", target.knownHostsAddress(), hostKey))
+	}
+	return knownHosts, nil
+}
+
+// fetchHostKeyWithRetry reads the host key offered during the SSH handshake,
+// retrying until the host responds or the timeout passes.
+func fetchHostKeyWithRetry(o terraform.UIOutput, host string, port int, connectTimeoutSeconds int, timeoutSeconds int) (string, error) {
+	timeoutMs := timeoutSeconds * 1000
+	timeSpentMs := 0
+	intervalMs := 5000
+	for {
+		hostKey, err := fetchHostKey(host, port, time.Duration(connectTimeoutSeconds)*time.Second)
+		if err == nil {
+			return hostKey, nil
+		}
+		o.Output(fmt.Sprintf("host key for '%s' not received yet (last error: %v); retrying...", host, err))
+		time.Sleep(time.Duration(intervalMs) * time.Millisecond)
+		timeSpentMs = timeSpentMs + intervalMs
+		if timeSpentMs > timeoutMs {
+			return "", fmt.Errorf("host key for '%s' not received within %d seconds", host, timeoutSeconds)
+		}
+	}
+}
+
+// fetchHostKey reads the host key offered during the SSH handshake.
+// The key is received before authentication, the host does not have to accept any credentials.
+func fetchHostKey(host string, port int, timeout time.Duration) (string, error) {
+	hostKey := ""
+	sshConfig := &ssh.ClientConfig{
+		User: DefaultUser,
+		Auth: []ssh.AuthMethod{},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+			return nil
+		},
+		Timeout: timeout,
+	}
+	client, err := ssh.Dial("tcp", sshAddress(host, port), sshConfig)
+	if err == nil {
+		client.Close()
+	}
+	if hostKey != "" {
+		return hostKey, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("no host key received")
+	}
+	return "", err
+}
@@ -0,0 +1,80 @@
+package mode
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+)
+
+func TestParseAnsibleInventoryList(t *testing.T) {
+	inventoryList := `{
+    "_meta": {
+        "hostvars": {
+            "web1": {"ansible_host": "10.0.0.11"},
+            "web2": {"ansible_host": "2001:db8::12", "ansible_port": 2022},
+            "db1": {"ansible_port": "2200"},
+            "controller": {"ansible_connection": "local"}
+        }
+    },
+    "all": {"children": ["ungrouped", "web", "db"]},
+    "ungrouped": {"hosts": ["controller"]},
+    "web": {"hosts": ["web1", "web2"]},
+    "db": {"hosts": ["db1"]}
+}`
+	targets, err := parseAnsibleInventoryList([]byte(inventoryList), 22)
+	if err != nil {
+		t.Fatalf("Expected inventory list to parse but got an error: %v", err)
+	}
+	expected := []string{"[db1]:2200", "10.0.0.11", "[2001:db8::12]:2022"}
+	if len(targets) != len(expected) {
+		t.Fatalf("Expected %d targets but got %d", len(expected), len(targets))
+	}
+	for idx, target := range targets {
+		if target.knownHostsAddress() != expected[idx] {
+			t.Fatalf("Expected target %s but got %s", expected[idx], target.knownHostsAddress())
+		}
+	}
+}
+
+func TestCollectKnownHostsUsesPinnedHostKeys(t *testing.T) {
+	ansibleSSHSettings := test.GetNewAnsibleSSHSettings(t, map[string]interface{}{
+		"host_keys": []interface{}{
+			"10.0.0.11 " + test.TestSSHHostKeyPublic,
+		},
+	})
+	// the pinned host is not contacted:
+	knownHosts, err := collectKnownHosts(new(terraform.MockUIOutput), []*sshTarget{
+		&sshTarget{alias: "web1", host: "10.0.0.11", port: 22},
+	}, nil, ansibleSSHSettings)
+	if err != nil {
+		t.Fatalf("Expected pinned host keys to be used but got an error: %v", err)
+	}
+	if len(knownHosts) != 1 || knownHosts[0] != "10.0.0.11 "+test.TestSSHHostKeyPublic {
+		t.Fatalf("Expected pinned host key only but got: %v", knownHosts)
+	}
+}
+
+func TestCollectKnownHostsWithoutCredentials(t *testing.T) {
+	instanceState := test.GetNewSSHInstanceState(t, "test-username")
+	output := new(terraform.MockUIOutput)
+	sshServer := test.GetConfiguredAndRunningSSHServer(t, "host-key-collector", false, instanceState, output)
+	defer sshServer.Stop()
+
+	port, _ := strconv.Atoi(instanceState.Ephemeral.ConnInfo["port"])
+	// the server does not authenticate the collector, the key is received during the handshake:
+	knownHosts, err := collectKnownHosts(output, []*sshTarget{
+		&sshTarget{alias: "null-resource-host", host: instanceState.Ephemeral.ConnInfo["host"], port: port},
+	}, nil, test.GetNewAnsibleSSHSettings(t, map[string]interface{}{}))
+	if err != nil {
+		t.Fatalf("Expected host key to be collected but got an error: %v", err)
+	}
+	// the collected entry has the key type and the key, without the comment:
+	publicKey := strings.Fields(test.TestSSHHostKeyPublic)
+	expected := knownHostsAddress(instanceState.Ephemeral.ConnInfo["host"], port) + " " + publicKey[0] + " " + publicKey[1]
+	if len(knownHosts) != 1 || knownHosts[0] != expected {
+		t.Fatalf("Expected known hosts entry '%s' but got: %v", expected, knownHosts)
+	}
+}
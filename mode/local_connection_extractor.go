@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/hashicorp/terraform/communicator/shared"
 	"github.com/hashicorp/terraform/terraform"
 	"github.com/mitchellh/mapstructure"
 	"golang.org/x/crypto/ssh"
@@ -77,9 +77,9 @@ func parseConnectionInfo(s *terraform.InstanceState) (*connectionInfo, error) {
 		connInfo.User = DefaultUser
 	}
 
-	// Format the host if needed.
-	// Needed for IPv6 support.
-	connInfo.Host = shared.IpFormat(connInfo.Host)
+	// Keep the raw host, IPv6 addresses are bracketed
+	// only where the consumer requires it.
+	connInfo.Host = rawHost(connInfo.Host)
 
 	if connInfo.Port == 0 {
 		connInfo.Port = DefaultPort
@@ -100,9 +100,7 @@ func parseConnectionInfo(s *terraform.InstanceState) (*connectionInfo, error) {
 	}
 	// Default all bastion config attrs to their non-bastion counterparts
 	if connInfo.BastionHost != "" {
-		// Format the bastion host if needed.
-		// Needed for IPv6 support.
-		connInfo.BastionHost = shared.IpFormat(connInfo.BastionHost)
+		connInfo.BastionHost = rawHost(connInfo.BastionHost)
 
 		if connInfo.BastionUser == "" {
 			connInfo.BastionUser = connInfo.User
@@ -134,6 +132,14 @@ func safeDuration(dur string, defaultDur time.Duration) time.Duration {
 	return d
 }
 
+// rawHost removes brackets from a bracketed IPv6 address.
+func rawHost(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	return host
+}
+
 func validatePrivateKey(key *string) error {
 	pk := []byte(*key)
 	block, _ := pem.Decode(pk)
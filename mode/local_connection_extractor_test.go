@@ -212,6 +212,31 @@ func TestLocalConnectionExtractorBastionInherits(t *testing.T) {
 	}
 }
 
+func TestLocalConnectionExtractorIPv6(t *testing.T) {
+	instanceState := &terraform.InstanceState{
+		Ephemeral: terraform.EphemeralState{
+			ConnInfo: map[string]string{
+				"type":         "ssh",
+				"user":         "test-username",
+				"host":         "2001:db8::10",
+				"port":         "2022",
+				"bastion_host": "[2001:db8::1]",
+			},
+		},
+	}
+
+	connInfo, err := parseConnectionInfo(instanceState)
+	if err != nil {
+		t.Fatal("Expected connection info but received an error", err)
+	}
+	if connInfo.Host != "2001:db8::10" {
+		t.Fatalf("Expected raw IPv6 Host but got %s", connInfo.Host)
+	}
+	if connInfo.BastionHost != "2001:db8::1" {
+		t.Fatalf("Expected raw IPv6 BastionHost but got %s", connInfo.BastionHost)
+	}
+}
+
 func TestInvalidDurationResultsInDefaultDuration(t *testing.T) {
 	defaultDuration := time.Duration(time.Second * 5)
 	returnedDuration := safeDuration("not a duration string", defaultDuration)
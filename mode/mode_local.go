@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"text/template"
 	"time"
@@ -97,8 +100,6 @@ func (v *LocalMode) Run(plays []*types.Play, ansibleSSHSettings *types.AnsibleSS
 				return fmt.Errorf("Hosts or Inventory file must be specified on each plays attribute when using null_resource")
 			}
 		}
-		// Force StrictHostKeyChecking=no for null_resource
-		ansibleSSHSettings.SetOverrideStrictHostKeyChecking()
 	}
 
 	bastionPemFile := ""
@@ -128,6 +129,17 @@ func (v *LocalMode) Run(plays []*types.Play, ansibleSSHSettings *types.AnsibleSS
 	knownHostsTarget := make([]string, 0)
 	knownHostsBastion := make([]string, 0)
 
+	// hosts of null_resource plays and inventory files:
+	additionalTargets := make([]*sshTarget, 0)
+	collectHostKeys := !ansibleSSHSettings.InsecureNoStrictHostKeyChecking() && ansibleSSHSettings.UserKnownHostsFile() == ""
+	if collectHostKeys || ansibleSSHSettings.ReadinessTimeoutSeconds() > 0 {
+		var err error
+		additionalTargets, err = v.resolveTargets(plays)
+		if err != nil {
+			return err
+		}
+	}
+
 	if bastion.inUse() {
 		// wait for bastion:
 		sshClient, err := bastion.connect()
@@ -137,27 +149,34 @@ func (v *LocalMode) Run(plays []*types.Play, ansibleSSHSettings *types.AnsibleSS
 		defer sshClient.Close()
 		if !ansibleSSHSettings.InsecureNoStrictHostKeyChecking() {
 			if ansibleSSHSettings.UserKnownHostsFile() == "" {
-				if target.hostKey() == "" {
-					v.o.Output(fmt.Sprintf("Host key not given, executing ssh-keyscan on bastion: %s@%s:%d",
-						bastion.user(),
-						bastion.host(),
-						bastion.port()))
-					targetKnownHosts, err := newBastionKeyScan(v.o,
-						sshClient,
-						target.host(),
-						target.port(),
-						ansibleSSHSettings.SSHKeyscanSeconds()).scan()
-					if err != nil {
-						return err
+				if compute_resource {
+					if target.hostKey() == "" {
+						v.o.Output(fmt.Sprintf("Host key not given, executing ssh-keyscan on bastion: %s@%s:%d",
+							bastion.user(),
+							bastion.host(),
+							bastion.port()))
+						targetKnownHosts, err := newBastionKeyScan(v.o,
+							sshClient,
+							target.host(),
+							target.port(),
+							ansibleSSHSettings.HostKeyCollectionSeconds()).scan()
+						if err != nil {
+							return err
+						}
+						// ssh-keyscan gave us full lines with hosts, like this:
+						// <ip> ecdsa-sha2-nistp256 AAAA...
+						// <ip> ssh-rsa AAAAB...
+						// <ip> ssh-ed25519 AAAAC...
+						knownHostsTarget = append(knownHostsTarget, targetKnownHosts)
+					} else {
+						knownHostsTarget = append(knownHostsTarget, fmt.Sprintf("%s %s", knownHostsAddress(target.host(), target.port()), target.hostKey()))
 					}
-					// ssh-keyscan gave us full lines with hosts, like this:
-					// <ip> ecdsa-sha2-nistp256 AAAA...
-					// <ip> ssh-rsa AAAAB...
-					// <ip> ssh-ed25519 AAAAC...
-					knownHostsTarget = append(knownHostsTarget, targetKnownHosts)
-				} else {
-					knownHostsTarget = append(knownHostsTarget, fmt.Sprintf("%s %s", target.host(), target.hostKey()))
 				}
+				additionalKnownHosts, err := collectKnownHosts(v.o, additionalTargets, sshClient, ansibleSSHSettings)
+				if err != nil {
+					return err
+				}
+				knownHostsTarget = append(knownHostsTarget, additionalKnownHosts...)
 			} else {
 				v.o.Output(fmt.Sprintf("bastion %s@%s:%d will use '%s' as a user known hosts file",
 					bastion.user(),
@@ -172,18 +191,18 @@ func (v *LocalMode) Run(plays []*types.Play, ansibleSSHSettings *types.AnsibleSS
 				bastion.host(),
 				bastion.port()))
 		}
-		knownHostsBastion = append(knownHostsBastion, fmt.Sprintf("%s %s", bastion.host(), bastion.hostKey()))
+		knownHostsBastion = append(knownHostsBastion, fmt.Sprintf("%s %s", knownHostsAddress(bastion.host(), bastion.port()), bastion.hostKey()))
 	} else {
 		if !ansibleSSHSettings.InsecureNoStrictHostKeyChecking() {
 			v.o.Output(fmt.Sprintf("InsecureNoStrictHostKeyChecking false"))
-			if compute_resource {
-				if ansibleSSHSettings.UserKnownHostsFile() == "" {
+			if ansibleSSHSettings.UserKnownHostsFile() == "" {
+				if compute_resource {
 					if target.hostKey() == "" {
 						v.o.Output(fmt.Sprintf("host key for '%s' not passed", target.host()))
 						// fetchHostKey will issue an ssh Dial and update the hostKey() value
 						// as with bastionKeyScan, we might ask for the host key while the instance
 						// is not ready to respond to SSH, we need to retry for a number of times
-						timeoutMs := ansibleSSHSettings.SSHKeyscanSeconds() * 1000
+						timeoutMs := ansibleSSHSettings.HostKeyCollectionSeconds() * 1000
 						timeSpentMs := 0
 						intervalMs := 5000
 
@@ -195,7 +214,7 @@ func (v *LocalMode) Run(plays []*types.Play, ansibleSSHSettings *types.AnsibleSS
 								if timeSpentMs > timeoutMs {
 									v.o.Output(fmt.Sprintf("host key for '%s' not received within %d seconds",
 										target.host(),
-										ansibleSSHSettings.SSHKeyscanSeconds()))
+										ansibleSSHSettings.HostKeyCollectionSeconds()))
 									return err
 								}
 							} else {
@@ -206,16 +225,18 @@ func (v *LocalMode) Run(plays []*types.Play, ansibleSSHSettings *types.AnsibleSS
 							return fmt.Errorf("expected to receive the host key for '%s', but no host key arrived", target.host())
 						}
 					}
-					knownHostsTarget = append(knownHostsTarget, fmt.Sprintf("%s %s", target.host(), target.hostKey()))
-				} else {
-					v.o.Output(fmt.Sprintf("using '%s' as a known hosts file", ansibleSSHSettings.UserKnownHostsFile()))
+					knownHostsTarget = append(knownHostsTarget, fmt.Sprintf("%s %s", knownHostsAddress(target.host(), target.port()), target.hostKey()))
+				}
+				additionalKnownHosts, err := collectKnownHosts(v.o, additionalTargets, nil, ansibleSSHSettings)
+				if err != nil {
+					return err
 				}
+				knownHostsTarget = append(knownHostsTarget, additionalKnownHosts...)
 			} else {
-				v.o.Output("null_resource, not verifying host keys")
-				// StrictHostKeyChecking=no set during play execution
+				v.o.Output(fmt.Sprintf("using '%s' as a known hosts file", ansibleSSHSettings.UserKnownHostsFile()))
 			}
 		} else {
-			v.o.Output("StrictHostKeyChecking=no specified or set for null_resource, not verifying host keys")
+			v.o.Output("StrictHostKeyChecking=no specified, not verifying host keys")
 		}
 	}
 
@@ -231,12 +252,39 @@ func (v *LocalMode) Run(plays []*types.Play, ansibleSSHSettings *types.AnsibleSS
 	}
 	defer os.Remove(knownHostsFileTarget)
 
+	if ansibleSSHSettings.ReadinessTimeoutSeconds() > 0 {
+		readinessTargets := make([]*sshTarget, 0)
+		if compute_resource {
+			readinessTargets = append(readinessTargets, &sshTarget{alias: target.host(), host: target.host(), port: target.port(), credentials: true})
+		}
+		readinessTargets = append(readinessTargets, additionalTargets...)
+		if err := v.waitForTargets(readinessTargets, knownHostsFileTarget, ansibleSSHSettings); err != nil {
+			return err
+		}
+	}
+
+	// plays may override provisioner level SSH settings,
+	// a ControlPath directory is shared by all plays requiring one under the same control_path_dir:
+	controlPathDirs := make(map[string]string)
+	defer func() {
+		for _, dir := range controlPathDirs {
+			v.cleanupControlPathDir(dir)
+		}
+	}()
+
 	for _, play := range plays {
 
 		if !play.Enabled() {
 			continue
 		}
 
+		playSSHSettings := ansibleSSHSettings.Merge(play.AnsibleSSHSettings())
+
+		controlPathDir, err := v.playControlPathDir(playSSHSettings, controlPathDirs)
+		if err != nil {
+			return err
+		}
+
 		inventoryFile, err := v.writeInventory(play)
 
 		if err != nil {
@@ -251,7 +299,7 @@ func (v *LocalMode) Run(plays []*types.Play, ansibleSSHSettings *types.AnsibleSS
 
 		// we can't pass bastion instance into this function
 		// we would end up with a circular import
-		command, err := play.ToLocalCommand(types.LocalModeAnsibleArgs{
+		ansibleArgs := types.LocalModeAnsibleArgs{
 			Username:              v.connInfo.User,
 			Port:                  v.connInfo.Port,
 			PemFile:               targetPemFile,
@@ -261,7 +309,29 @@ func (v *LocalMode) Run(plays []*types.Play, ansibleSSHSettings *types.AnsibleSS
 			BastionPemFile:        bastionPemFile,
 			BastionPort:           bastion.port(),
 			BastionUsername:       bastion.user(),
-		}, ansibleSSHSettings)
+			ControlPathDir:        controlPathDir,
+		}
+
+		if playSSHSettings.GenerateSSHConfig() {
+			sshConfigFile, err := v.writeSSHConfig(play, ansibleArgs, playSSHSettings)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(sshConfigFile)
+			ansibleArgs.SSHConfigFile = sshConfigFile
+		}
+
+		// run in the project_root so relative lookups behave like in the remote provisioner:
+		workingDir := ""
+		if playbook, ok := play.Entity().(*types.Playbook); ok && playbook.ProjectRoot() != "" {
+			playbookRelPath := ""
+			if workingDir, playbookRelPath, err = resolvePlaybookDirectory(playbook); err != nil {
+				return err
+			}
+			playbook.SetOverrideFilePath(filepath.Join(workingDir, playbookRelPath))
+		}
+
+		command, err := play.ToLocalCommand(ansibleArgs, playSSHSettings)
 
 		if err != nil {
 			return err
@@ -269,7 +339,7 @@ func (v *LocalMode) Run(plays []*types.Play, ansibleSSHSettings *types.AnsibleSS
 
 		v.o.Output(fmt.Sprintf("running local command: %s", command))
 
-		if err := v.runCommand(command); err != nil {
+		if err := v.runCommand(command, workingDir); err != nil {
 			return err
 		}
 
@@ -278,6 +348,57 @@ func (v *LocalMode) Run(plays []*types.Play, ansibleSSHSettings *types.AnsibleSS
 	return nil
 }
 
+// playControlPathDir returns the ControlPath directory for a play, empty string when the play does not use ControlPersist.
+// The directory is created under control_path_dir of the play on first use and recorded in dirs.
+func (v *LocalMode) playControlPathDir(playSSHSettings *types.AnsibleSSHSettings, dirs map[string]string) (string, error) {
+	if playSSHSettings.ControlPersistSeconds() <= 0 {
+		return "", nil
+	}
+	if dir, ok := dirs[playSSHSettings.ControlPathDir()]; ok {
+		return dir, nil
+	}
+	dir, err := v.createControlPathDir(playSSHSettings.ControlPathDir())
+	if err != nil {
+		return "", err
+	}
+	dirs[playSSHSettings.ControlPathDir()] = dir
+	return dir, nil
+}
+
+// createControlPathDir creates a private directory for SSH ControlPath sockets of this run.
+func (v *LocalMode) createControlPathDir(parent string) (string, error) {
+	// keep the directory name short, unix socket paths are limited to ~100 characters:
+	dir, err := ioutil.TempDir(parent, "tf-ansible-cp")
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	v.o.Output(fmt.Sprintf("Using SSH ControlPath directory '%s'...", dir))
+	return dir, nil
+}
+
+// cleanupControlPathDir stops any SSH master connections left behind by Ansible
+// and removes the ControlPath directory.
+func (v *LocalMode) cleanupControlPathDir(dir string) {
+	entries, err := ioutil.ReadDir(dir)
+	if err == nil {
+		for _, entry := range entries {
+			if entry.Mode()&os.ModeSocket == 0 {
+				continue
+			}
+			socket := filepath.Join(dir, entry.Name())
+			// the host is required by ssh but not used when the ControlPath is given:
+			if err := exec.Command("ssh", "-o", fmt.Sprintf("ControlPath=%s", socket), "-O", "exit", "tf-ansible").Run(); err != nil {
+				log.Printf("[DEBUG] failed stopping SSH master connection '%s': %v", socket, err)
+			}
+		}
+	}
+	os.RemoveAll(dir)
+}
+
 func (v *LocalMode) writeKnownHosts(knownHosts []string) (string, error) {
 	trimmedKnownHosts := make([]string, 0)
 	for _, entry := range knownHosts {
@@ -296,6 +417,24 @@ func (v *LocalMode) writeKnownHosts(knownHosts []string) (string, error) {
 	return file.Name(), nil
 }
 
+func (v *LocalMode) writeSSHConfig(play *types.Play, ansibleArgs types.LocalModeAnsibleArgs, ansibleSSHSettings *types.AnsibleSSHSettings) (string, error) {
+	sshConfig, err := play.ToSSHConfig(ansibleArgs, ansibleSSHSettings)
+	if err != nil {
+		return "", err
+	}
+	file, err := ioutil.TempFile(os.TempDir(), "temporary-ssh-config")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	v.o.Output(fmt.Sprintf("Writing temporary ssh_config to '%s'...", file.Name()))
+	// ssh refuses config files writable by others:
+	if err := ioutil.WriteFile(file.Name(), []byte(sshConfig), 0600); err != nil {
+		return "", err
+	}
+	return file.Name(), nil
+}
+
 func (v *LocalMode) writePem(pk string) (string, error) {
 	if v.connInfo.PrivateKey != "" {
 		file, err := ioutil.TempFile(os.TempDir(), uuid.NewV4().String())
@@ -382,7 +521,7 @@ func (v *LocalMode) writeInventory(play *types.Play) (string, error) {
 	return play.InventoryFile(), nil
 }
 
-func (v *LocalMode) runCommand(command string) error {
+func (v *LocalMode) runCommand(command string, workingDir string) error {
 	localExecProvisioner := localExec.Provisioner()
 
 	instanceState := &terraform.InstanceState{
@@ -401,10 +540,12 @@ func (v *LocalMode) runCommand(command string) error {
 	config := &terraform.ResourceConfig{
 		ComputedKeys: make([]string, 0),
 		Raw: map[string]interface{}{
-			"command": command,
+			"command":     command,
+			"working_dir": workingDir,
 		},
 		Config: map[string]interface{}{
-			"command": command,
+			"command":     command,
+			"working_dir": workingDir,
 		},
 	}
 
@@ -3,7 +3,9 @@ package mode
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -72,6 +74,83 @@ func TestLocalInventoryTemplateGeneratesWithoutAlias(t *testing.T) {
 
 }
 
+func TestLocalInventoryIPv6(t *testing.T) {
+	for _, tc := range []struct {
+		connHost string
+		hosts    []interface{}
+		expected string
+	}{
+		// the compute resource host is the alias:
+		{"2001:db8::10", []interface{}{}, "2001:db8::10\n"},
+		{"[2001:db8::10]", []interface{}{}, "2001:db8::10\n"},
+		// an alias from plays.hosts, Ansible connects to the raw address:
+		{"2001:db8::10", []interface{}{"web"}, "web ansible_host=2001:db8::10\n"},
+		{"[2001:db8::10]", []interface{}{"web"}, "web ansible_host=2001:db8::10\n"},
+	} {
+		instanceState := test.GetNewSSHInstanceState(t, "test-username")
+		instanceState.Ephemeral.ConnInfo["host"] = tc.connHost
+		modeLocal, err := NewLocalMode(new(terraform.MockUIOutput), instanceState)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		inventoryFile, err := modeLocal.writeInventory(getTestPlaybookPlay(t, "site.yml", map[string]interface{}{
+			"hosts":  tc.hosts,
+			"groups": []interface{}{"servers"},
+		}))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		data, err := ioutil.ReadFile(inventoryFile)
+		os.Remove(inventoryFile)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !strings.HasPrefix(string(data), tc.expected) || !strings.Contains(string(data), "[servers]\n"+tc.expected) {
+			t.Fatalf("Expected '%s' for the host '%s' and hosts %v but got:\n%s", strings.TrimSpace(tc.expected), tc.connHost, tc.hosts, string(data))
+		}
+	}
+}
+
+func TestLocalModeControlPathDirPerPlay(t *testing.T) {
+	parent1, err := ioutil.TempDir("", "control-path-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(parent1)
+	parent2, err := ioutil.TempDir("", "control-path-2")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(parent2)
+
+	v := &LocalMode{o: new(terraform.MockUIOutput)}
+	dirs := make(map[string]string)
+	controlPathDir := func(raw map[string]interface{}) string {
+		dir, err := v.playControlPathDir(test.GetNewAnsibleSSHSettings(t, raw), dirs)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return dir
+	}
+
+	dir1 := controlPathDir(map[string]interface{}{"control_persist": 60, "control_path_dir": parent1})
+	if filepath.Dir(dir1) != parent1 {
+		t.Fatalf("Expected a ControlPath directory under '%s' but got '%s'", parent1, dir1)
+	}
+	if dir := controlPathDir(map[string]interface{}{"control_persist": 30, "control_path_dir": parent1}); dir != dir1 {
+		t.Fatalf("Expected plays under the same control_path_dir to share '%s' but got '%s'", dir1, dir)
+	}
+	if dir := controlPathDir(map[string]interface{}{"control_persist": 60, "control_path_dir": parent2}); filepath.Dir(dir) != parent2 {
+		t.Fatalf("Expected a ControlPath directory under '%s' but got '%s'", parent2, dir)
+	}
+	if dir := controlPathDir(map[string]interface{}{"control_persist": 0, "control_path_dir": parent2}); dir != "" {
+		t.Fatalf("Did not expect a ControlPath directory without control_persist but got '%s'", dir)
+	}
+	if len(dirs) != 2 {
+		t.Fatalf("Expected two ControlPath directories but got: %v", dirs)
+	}
+}
+
 func TestIntegrationLocalModeProvisioning(t *testing.T) {
 
 	testModuleName := "ping"
@@ -5,17 +5,20 @@ import (
 	"bytes"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 	"time"
 
 	linereader "github.com/mitchellh/go-linereader"
+	"github.com/radekg/terraform-provisioner-ansible/v2/shellescape"
 	"github.com/radekg/terraform-provisioner-ansible/v2/types"
 
 	"github.com/hashicorp/terraform/communicator"
@@ -24,46 +27,169 @@ import (
 	uuid "github.com/satori/go.uuid"
 )
 
+const (
+	// the default installer records the installed Ansible in the marker file, the installer is skipped
+	// when the marker matches the requested installation:
+	remoteInstallMarkerPath = "/var/lib/tf-ansible/installed"
+	// exit code of the marker check command when the requested installation is recorded:
+	remoteInstallMarkerExitCode = 50
+	remoteInstallVenvPath       = "/opt/tf-ansible/venv"
+	remoteInstallPipxHome       = "/opt/tf-ansible/pipx"
+	remoteInstallBinDir         = "/usr/local/bin"
+	// installs from the uploaded remote.offline_bundle into the venv:
+	remoteInstallMethodOffline = "offline"
+	// used instead of a noexec remote_installer_directory, relative to the home directory of the connection user:
+	remoteInstallerHomeDirectory = ".tf-ansible"
+	// exit code of the bootstrap directory command when the directory is not a directory owned by the user:
+	bootstrapDirectoryNotOwnedExitCode = 51
+)
+
 // The command is executed with sudo, we do not need sudo here.
 const installerProgramTemplate = `#!/bin/sh
-if [ -n "${TAF_DOCKER_CENTOS_LATEST_RUN}" ]; then
+if [ -n "${TAF_DOCKER_CENTOS_LATEST_RUN:-}" ]; then
   yum update -y && yum install -y which
 fi
-set -euo pipefail
-if [ -z "$(which ansible-playbook)" ]; then
-  # only check the cloud boot finished if the directory exists
-  if [ -d /var/lib/cloud/instance ]; then
-    while [ ! -f /var/lib/cloud/instance/boot-finished ]; do
-      sleep 1
-    done
+set -eu
+method="{{ .Method }}"
+package="{{ .Package }}"
+version="{{ .Version }}"
+marker="{{ .MarkerPath }}"
+venv="{{ .VenvPath }}"
+pipx_home="{{ .PipxHome }}"
+bin_dir="{{ .BinDir }}"
+offline_bundle="{{ .OfflineBundle }}"
+
+# only check the cloud boot finished if the directory exists
+while ! { ` + cloudInitBootFinished + `; }; do
+  sleep 1
+done
+
+package_manager=""
+for candidate in apt-get dnf yum apk zypper; do
+  if command -v "$candidate" >/dev/null 2>&1; then
+    package_manager="$candidate"
+    break
   fi
-  # install dependencies
-  if [ -f /etc/redhat-release ]; then
-    yum update -y \
-    && yum groupinstall -y "Development Tools" \
-    && yum install -y python-devel curl
-  else
-    apt-get update \
-    && apt-get install -y build-essential python-dev curl
-  fi
-  # install pip, if necessary
-  if [ -z "$(which pip)" ]; then
-    curl https://bootstrap.pypa.io/get-pip.py | python
+done
+
+install_packages() {
+  if [ -z "$package_manager" ]; then
+    echo "No supported package manager found, expected one of: apt-get, dnf, yum, apk, zypper." >&2
+    exit 1
   fi
-  # install ansible
-  pip install {{ .AnsibleVersion}}
-else
-  expected_version="{{ .AnsibleVersion}}"
-  installed_version=$(ansible-playbook --version | head -n1 | awk '{print $2}')
-  installed_version="ansible==$installed_version"
-  case "$expected_version" in
-	*==*)
-	  if [ "$expected_version" != "$installed_version" ]; then
-        pip install $expected_version
-	  fi
-	;;
+  case "$package_manager" in
+    apt-get) apt-get update && DEBIAN_FRONTEND=noninteractive apt-get install -y "$@" ;;
+    dnf) dnf install -y "$@" ;;
+    yum) yum install -y "$@" ;;
+    apk) apk add --no-cache "$@" ;;
+    zypper) zypper --non-interactive install "$@" ;;
+  esac
+}
+
+pip_spec="$package"
+if [ -n "$version" ]; then
+  pip_spec="$package==$version"
+fi
+
+case "$method" in
+  pip)
+    case "$package_manager" in
+      apk) install_packages python3 py3-pip ;;
+      *) install_packages python3 python3-pip ;;
+    esac
+    if ls /usr/lib/python3*/EXTERNALLY-MANAGED >/dev/null 2>&1; then
+      echo "The system Python is externally managed, use install_method venv or pipx." >&2
+      exit 1
+    fi
+    python3 -m pip install "$pip_spec"
+    installed_version=$(python3 -m pip show "$package" | sed -n 's/^Version: //p')
+    ;;
+  venv)
+    case "$package_manager" in
+      apt-get) install_packages python3 python3-venv ;;
+      apk) install_packages python3 py3-pip ;;
+      *) install_packages python3 ;;
+    esac
+    python3 -m venv "$venv"
+    "$venv/bin/python" -m pip install --upgrade pip
+    "$venv/bin/python" -m pip install "$pip_spec"
+    mkdir -p "$bin_dir"
+    for program in "$venv"/bin/ansible*; do
+      ln -sf "$program" "$bin_dir/"
+    done
+    installed_version=$("$venv/bin/python" -m pip show "$package" | sed -n 's/^Version: //p')
+    ;;
+  pipx)
+    case "$package_manager" in
+      zypper) install_packages python3-pipx ;;
+      *) install_packages pipx ;;
+    esac
+    PIPX_HOME="$pipx_home" PIPX_BIN_DIR="$bin_dir" pipx install --force --include-deps "$pip_spec"
+    installed_version=$(PIPX_HOME="$pipx_home" pipx runpip "$package" show "$package" | sed -n 's/^Version: //p')
+    ;;
+  offline)
+    # no network access, the host must provide python3 with the venv module:
+    if ! python3 -m venv "$venv"; then
+      echo "Offline installation requires python3 with the venv module." >&2
+      exit 1
+    fi
+    find_links=""
+    for wheels_dir in $(find "$offline_bundle" -name '*.whl' -exec dirname {} \; | sort -u); do
+      find_links="$find_links --find-links $wheels_dir"
+    done
+    if [ -z "$find_links" ]; then
+      echo "No wheels found in the offline bundle." >&2
+      exit 1
+    fi
+    "$venv/bin/python" -m pip install --no-index $find_links "$pip_spec"
+    mkdir -p "$bin_dir"
+    for program in "$venv"/bin/ansible*; do
+      ln -sf "$program" "$bin_dir/"
+    done
+    installed_version=$("$venv/bin/python" -m pip show "$package" | sed -n 's/^Version: //p')
+    ;;
+  package)
+    package_spec="$package"
+    if [ -n "$version" ]; then
+      case "$package_manager" in
+        dnf|yum) package_spec="$package-$version" ;;
+        *) package_spec="$package=$version" ;;
+      esac
+    fi
+    install_packages "$package_spec"
+    case "$package_manager" in
+      apt-get) installed_version=$(dpkg-query -W -f='${Version}' "$package") ;;
+      apk) installed_version=$(apk list --installed "$package" | awk '{print $1}' | sed "s/^$package-//") ;;
+      *) installed_version=$(rpm -q --qf '%{VERSION}' "$package") ;;
+    esac
+    ;;
+  *)
+    echo "Unsupported install method: $method." >&2
+    exit 1
+    ;;
+esac
+
+# verify the installation:
+if [ -z "$installed_version" ]; then
+  echo "Could not determine the installed $package version." >&2
+  exit 1
+fi
+if [ -n "$version" ]; then
+  case "$installed_version" in
+    "$version"|"$version".*|"$version"-*) ;;
+    *)
+      echo "Expected $package $version but $installed_version is installed." >&2
+      exit 1
+      ;;
   esac
 fi
+if ! command -v ansible-playbook >/dev/null 2>&1; then
+  echo "$package $installed_version installed but ansible-playbook is not on the PATH." >&2
+  exit 1
+fi
+mkdir -p "$(dirname "$marker")"
+echo "$method $package==$installed_version" > "$marker"
+echo "Installed $package $installed_version using $method."
 `
 
 type inventoryTemplateRemoteData struct {
@@ -90,12 +216,31 @@ const defaultAnsibleGalaxyRolesPath = "ansible-galaxy-roles"
 type RemoteMode struct {
 	o              terraform.UIOutput
 	comm           communicator.Communicator
+	resourceID     string
 	connInfo       *connectionInfo
 	remoteSettings *types.RemoteSettings
+	// the filesystem of the host, available once connected:
+	fs remoteFS
+	// starts the commands of the plays when remote.forward_agent is set:
+	agentForwarding *agentForwardingStarter
+	// remote path of the uploaded remote.network.ca_bundle:
+	remoteCABundle string
+	// uploaded files with secrets, removed right after the play using them:
+	sensitiveFiles map[*types.Play][]string
+	// remote paths of the uploaded remote.pull deploy keys of the plays:
+	pullDeployKeys map[*types.Play]string
 }
 
 type ansibleInstaller struct {
-	AnsibleVersion string
+	Method     string
+	Package    string
+	Version    string
+	MarkerPath string
+	VenvPath   string
+	PipxHome   string
+	BinDir     string
+	// remote directory of the uploaded offline bundle:
+	OfflineBundle string
 }
 
 // NewRemoteMode returns configured remote mode provisioner.
@@ -117,9 +262,31 @@ func NewRemoteMode(o terraform.UIOutput, s *terraform.InstanceState, remoteSetti
 		return nil, err
 	}
 
+	if remoteSettings.BecomePassword() != "" && remoteSettings.BecomeCommand() != types.RemoteBecomeSudo {
+		return nil, fmt.Errorf("remote.become_password requires become_command '%s', %s reads the password from a terminal",
+			types.RemoteBecomeSudo, remoteSettings.BecomeCommand())
+	}
+
+	// the bootstrap directory is private to the connection user, only root reads it as well:
+	if user := remoteSettings.BecomeUser(); remoteSettings.UseSudo() && user != "" && user != "root" && user != connInfo.User {
+		return nil, fmt.Errorf("remote.become_user '%s' can not read the private bootstrap directory of the connection user '%s', use 'root' or the connection user",
+			user, connInfo.User)
+	}
+
+	if remoteSettings.Detached() {
+		// the detached play has no input and outlives the session forwarding the agent:
+		if remoteSettings.BecomePassword() != "" {
+			return nil, fmt.Errorf("remote.detached can not be used with remote.become_password, a detached play can not read the password")
+		}
+		if remoteSettings.ForwardAgent() {
+			return nil, fmt.Errorf("remote.detached can not be used with remote.forward_agent, the forwarded agent is gone once the session ends")
+		}
+	}
+
 	return &RemoteMode{
 		o:              o,
 		comm:           comm,
+		resourceID:     s.ID,
 		connInfo:       connInfo,
 		remoteSettings: remoteSettings,
 	}, nil
@@ -127,6 +294,10 @@ func NewRemoteMode(o terraform.UIOutput, s *terraform.InstanceState, remoteSetti
 
 // Run executes remote provisioning process.
 func (v *RemoteMode) Run(plays []*types.Play) error {
+	if err := v.checkPullPlays(plays); err != nil {
+		return err
+	}
+
 	// Wait and retry until we establish the connection
 	err := v.retryFunc(v.comm.Timeout(), func() error {
 		return v.comm.Connect(v.o)
@@ -136,6 +307,50 @@ func (v *RemoteMode) Run(plays []*types.Play) error {
 	}
 	defer v.comm.Disconnect()
 
+	v.fs = v.openRemoteFS()
+	defer func() {
+		// a detached play may reopen the filesystem after reconnecting:
+		v.fs.Close()
+	}()
+
+	if v.remoteSettings.ForwardAgent() {
+		v.agentForwarding, err = newAgentForwardingStarter(v.connInfo)
+		if err != nil {
+			return err
+		}
+		defer v.agentForwarding.Close()
+	}
+
+	if err := v.prepareBootstrapDirectory(); err != nil {
+		return err
+	}
+
+	succeeded := false
+	defer func() {
+		// secrets never remain on the host, whatever the cleanup policy:
+		for _, play := range plays {
+			v.removeSensitiveFiles(play)
+		}
+		switch v.remoteSettings.Cleanup() {
+		case types.RemoteCleanupAlways:
+			v.cleanupAfterBootstrap()
+		case types.RemoteCleanupOnSuccess:
+			if succeeded {
+				v.cleanupAfterBootstrap()
+			} else {
+				v.o.Output(fmt.Sprintf("Provisioning failed, leaving '%s' in place for inspection.", v.remoteSettings.BootstrapDirectory()))
+			}
+		}
+	}()
+
+	if err := v.preflight(); err != nil {
+		return err
+	}
+
+	if err := v.uploadNetworkCABundle(); err != nil {
+		return err
+	}
+
 	err = v.deployAnsibleData(plays)
 
 	if err != nil {
@@ -149,27 +364,77 @@ func (v *RemoteMode) Run(plays []*types.Play) error {
 		}
 	}
 
-	for _, play := range plays {
-		command, err := play.ToCommand(types.LocalModeAnsibleArgs{Username: v.connInfo.User})
+	for i, play := range plays {
+		command, err := v.toRemoteCommand(play)
+		if err != nil {
+			return err
+		}
+		logPath, err := v.preparePlayLog(i)
 		if err != nil {
 			return err
 		}
 		v.o.Output(fmt.Sprintf("running command: %s", command))
-		if err := v.runCommandSudo(command); err != nil {
+		started := time.Now()
+		dir := ""
+		// ansible-pull runs in the checkout of the repository:
+		if playbook, ok := play.Entity().(*types.Playbook); ok && !v.remoteSettings.Pull().InUse() {
+			dir = playbook.ProjectRoot()
+		}
+		if v.remoteSettings.Detached() {
+			err = v.runDetachedPlayCommand(i, dir, command, logPath)
+		} else {
+			err = v.runPlayCommand(dir, command, logPath)
+		}
+		v.removeSensitiveFiles(play)
+		// the log of a failed play is the one needed most:
+		v.downloadPlayLog(logPath, i, started)
+		if err != nil {
 			return err
 		}
 	}
 
-	if !v.remoteSettings.SkipCleanup() {
-		v.cleanupAfterBootstrap()
+	if err := v.fetchArtifacts(); err != nil {
+		return err
 	}
 
+	succeeded = true
 	return nil
 
 }
 
+func (v *RemoteMode) addSensitiveFile(play *types.Play, path string) {
+	if v.sensitiveFiles == nil {
+		v.sensitiveFiles = make(map[*types.Play][]string)
+	}
+	v.sensitiveFiles[play] = append(v.sensitiveFiles[play], path)
+}
+
+// removeSensitiveFiles securely deletes the uploaded files with secrets of the play,
+// shred is used when available.
+func (v *RemoteMode) removeSensitiveFiles(play *types.Play) {
+	paths := v.sensitiveFiles[play]
+	if len(paths) == 0 {
+		return
+	}
+	delete(v.sensitiveFiles, play)
+	quoted := make([]string, 0)
+	for _, path := range paths {
+		quoted = append(quoted, fmt.Sprintf("\"%s\"", path))
+	}
+	files := strings.Join(quoted, " ")
+	if err := v.runCommandNoSudo(fmt.Sprintf("shred -u %s 2>/dev/null || rm -f %s", files, files)); err != nil {
+		v.o.Output(fmt.Sprintf("Failed removing sensitive files %s: %v", files, err))
+	}
+}
+
 // retryFunc is used to retry a function for a given duration
 func (v *RemoteMode) retryFunc(timeout time.Duration, f func() error) error {
+	return v.retryFuncWithBackoff(timeout, 3*time.Second, 3*time.Second, f)
+}
+
+// retryFuncWithBackoff retries until the function succeeds or the timeout passes,
+// the delay between the attempts doubles up to the maximum delay.
+func (v *RemoteMode) retryFuncWithBackoff(timeout time.Duration, delay time.Duration, maxDelay time.Duration, f func() error) error {
 	finish := time.After(timeout)
 	for {
 		err := f()
@@ -181,7 +446,11 @@ func (v *RemoteMode) retryFunc(timeout time.Duration, f func() error) error {
 		select {
 		case <-finish:
 			return err
-		case <-time.After(3 * time.Second):
+		case <-time.After(delay):
+		}
+		delay = delay * 2
+		if delay > maxDelay {
+			delay = maxDelay
 		}
 	}
 }
@@ -202,66 +471,56 @@ func (v *RemoteMode) deployAnsibleData(plays []*types.Play) error {
 		switch entity := play.Entity().(type) {
 		case *types.Playbook:
 
-			playbookPath, err := types.ResolvePath(entity.FilePath())
+			// the inventory and the Vault files of the run are never part of the upload:
+			remotePlayDir := filepath.Join(v.remoteSettings.BootstrapDirectory(), v.getMD5Hash(entity.FilePath()))
+
+			if v.remoteSettings.Pull().InUse() {
+				if err := v.deployPullPlaybook(play, remotePlayDir); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// upload the entire project_root or, if not given, the playbook path's directory:
+			playbookDir, playbookRelPath, err := resolvePlaybookDirectory(entity)
 			if err != nil {
 				return err
 			}
 
-			// playbook file is at the top level of the module
-			// parse the playbook path's directory and upload the entire directory
-			playbookDir := filepath.Dir(playbookPath)
-			playbookDirHash := v.getMD5Hash(playbookDir)
-
-			remotePlaybookDir := filepath.Join(v.remoteSettings.BootstrapDirectory(), playbookDirHash)
-			remotePlaybookPath := filepath.Join(remotePlaybookDir, filepath.Base(playbookPath))
-
-			if err := v.runCommandNoSudo(fmt.Sprintf("mkdir -p \"%s\"",
-				v.remoteSettings.BootstrapDirectory())); err != nil {
+			if err := v.fs.MkdirAll(v.remoteSettings.BootstrapDirectory()); err != nil {
 				return err
 			}
 
-			dirExists, err := v.checkRemoteDirExists(remotePlaybookDir)
+			if entity.ProjectRoot() != "" {
+				v.o.Output(fmt.Sprintf("Uploading the project root '%s' of playbook '%s'...", playbookDir, entity.FilePath()))
+			} else {
+				v.o.Output(fmt.Sprintf("Uploading the parent directory '%s' of playbook '%s'...", playbookDir, entity.FilePath()))
+			}
+			remotePlaybookDir, err := v.uploadDirContentAddressed(playbookDir, entity.UploadExclude())
 			if err != nil {
 				return err
 			}
-			if dirExists {
-				v.o.Output(fmt.Sprintf("The playbook '%s' directory '%s' has been already uploaded.", entity.FilePath(), playbookDir))
-			} else {
-				v.o.Output(fmt.Sprintf("Uploading the parent directory '%s' of playbook '%s' to '%s'...", playbookDir, entity.FilePath(), remotePlaybookDir))
-				// upload ansible source and playbook to the host
-				if err := v.comm.UploadDir(remotePlaybookDir, playbookDir); err != nil {
-					return err
-				}
-			}
+			remotePlaybookPath := filepath.Join(remotePlaybookDir, playbookRelPath)
 
 			entity.SetOverrideFilePath(remotePlaybookPath)
+			if entity.ProjectRoot() != "" {
+				entity.SetOverrideProjectRoot(remotePlaybookDir)
+			}
+
+			if err := v.fs.MkdirAll(remotePlayDir); err != nil {
+				return err
+			}
 
 			// always create temp inventory:
-			inventoryFile, err := v.writeInventory(remotePlaybookDir, play)
+			inventoryFile, err := v.writeInventory(remotePlayDir, play)
 			if err != nil {
 				return err
 			}
 			play.SetOverrideInventoryFile(inventoryFile)
 
 			// always handle Vault ID or password file
-			if len(play.VaultID()) > 0 {
-				overrideVaultIDs := make([]string, 0)
-				for _, vaultID := range play.VaultID() {
-					uploadedVaultIDPath, err := v.uploadVaultPasswordOrIDFile(remotePlaybookDir, vaultID)
-					if err != nil {
-						return err
-					}
-					if uploadedVaultIDPath != "" {
-						overrideVaultIDs = append(overrideVaultIDs, uploadedVaultIDPath)
-					}
-				}
-				play.SetOverrideVaultID(overrideVaultIDs)
-			} else {
-				uploadedVaultPasswordFilePath, err := v.uploadVaultPasswordOrIDFile(remotePlaybookDir, play.VaultPasswordFile())
-				if err != nil {
-					return err
-				}
-				play.SetOverrideVaultPasswordPath(uploadedVaultPasswordFilePath)
+			if err := v.uploadVaultFiles(play, remotePlayDir); err != nil {
+				return err
 			}
 
 			// upload roles paths, if any:
@@ -273,58 +532,43 @@ func (v *RemoteMode) deployAnsibleData(plays []*types.Play) error {
 					continue
 				}
 
-				resolvedPath, err := types.ResolvePath(path)
-				if err != nil {
-					return err
-				}
-				dirHash := v.getMD5Hash(resolvedPath)
-				remoteDir := filepath.Join(v.remoteSettings.BootstrapDirectory(), dirHash)
-				dirExists, err := v.checkRemoteDirExists(remoteDir)
-
+				remoteDir, err := v.uploadPlaybookPath(entity, "roles", path, playbookDir, remotePlaybookDir)
 				if err != nil {
 					return err
 				}
-				if dirExists {
-					v.o.Output(fmt.Sprintf("Roles path '%s' has been already uploaded.", resolvedPath))
-				} else {
-					v.o.Output(fmt.Sprintf("Uploading roles path '%s' to '%s'...", resolvedPath, remoteDir))
-					// upload ansible source and playbook to the host
-					if err := v.comm.UploadDir(remoteDir, resolvedPath); err != nil {
-						return err
-					}
-				}
 				remoteRolesPath = append(remoteRolesPath, remoteDir)
 			}
 			entity.SetOverrideRolesPath(remoteRolesPath)
 
+			// upload collections, modules and plugins paths, if any:
+			remoteCollectionsPath, err := v.uploadPlaybookPaths(entity, "collections", entity.CollectionsPath(), playbookDir, remotePlaybookDir)
+			if err != nil {
+				return err
+			}
+			entity.SetOverrideCollectionsPath(remoteCollectionsPath)
+			remoteLibraryPath, err := v.uploadPlaybookPaths(entity, "library", entity.LibraryPath(), playbookDir, remotePlaybookDir)
+			if err != nil {
+				return err
+			}
+			entity.SetOverrideLibraryPath(remoteLibraryPath)
+			remotePluginPaths, err := v.uploadPlaybookPaths(entity, "plugins", entity.PluginPaths(), playbookDir, remotePlaybookDir)
+			if err != nil {
+				return err
+			}
+			entity.SetOverridePluginPaths(remotePluginPaths)
+
 		case *types.Module:
 
 			moduleDirHash := v.getMD5Hash(entity.Module())
 			remoteModuleDir := filepath.Join(v.remoteSettings.BootstrapDirectory(), moduleDirHash)
 
-			if err := v.runCommandNoSudo(fmt.Sprintf("mkdir -p \"%s\"", remoteModuleDir)); err != nil {
+			if err := v.fs.MkdirAll(remoteModuleDir); err != nil {
 				return err
 			}
 
 			// always handle Vault ID or password file
-			if len(play.VaultID()) > 0 {
-				overrideVaultIDs := make([]string, 0)
-				for _, vaultID := range play.VaultID() {
-					uploadedVaultIDPath, err := v.uploadVaultPasswordOrIDFile(remoteModuleDir, vaultID)
-					if err != nil {
-						return err
-					}
-					if uploadedVaultIDPath != "" {
-						overrideVaultIDs = append(overrideVaultIDs, uploadedVaultIDPath)
-					}
-				}
-				play.SetOverrideVaultID(overrideVaultIDs)
-			} else {
-				uploadedVaultPasswordFilePath, err := v.uploadVaultPasswordOrIDFile(remoteModuleDir, play.VaultPasswordFile())
-				if err != nil {
-					return err
-				}
-				play.SetOverrideVaultPasswordPath(uploadedVaultPasswordFilePath)
+			if err := v.uploadVaultFiles(play, remoteModuleDir); err != nil {
+				return err
 			}
 
 			// always create temp inventory:
@@ -336,8 +580,7 @@ func (v *RemoteMode) deployAnsibleData(plays []*types.Play) error {
 
 		case *types.GalaxyInstall:
 
-			if err := v.runCommandNoSudo(fmt.Sprintf("mkdir -p \"%s\"",
-				v.remoteSettings.BootstrapDirectory())); err != nil {
+			if err := v.fs.MkdirAll(v.remoteSettings.BootstrapDirectory()); err != nil {
 				return err
 			}
 
@@ -350,7 +593,7 @@ func (v *RemoteMode) deployAnsibleData(plays []*types.Play) error {
 			}
 			entity.SetRolesPath(rolesPathDir)
 			v.o.Output(fmt.Sprintf("galaxy_install roles path used is: '%s'...", entity.RolesPath()))
-			if err := v.runCommandNoSudo(fmt.Sprintf("mkdir -p \"%s\"", entity.RolesPath())); err != nil {
+			if err := v.fs.MkdirAll(entity.RolesPath()); err != nil {
 				return err
 			}
 
@@ -377,57 +620,94 @@ func (v *RemoteMode) deployAnsibleData(plays []*types.Play) error {
 	return nil
 }
 
+// uploadPlaybookPaths uploads the directories referenced by a playbook and returns their remote paths.
+func (v *RemoteMode) uploadPlaybookPaths(playbook *types.Playbook, kind string, paths []string, playbookDir string, remotePlaybookDir string) ([]string, error) {
+	remotePaths := make([]string, 0)
+	for _, path := range paths {
+		remoteDir, err := v.uploadPlaybookPath(playbook, kind, path, playbookDir, remotePlaybookDir)
+		if err != nil {
+			return nil, err
+		}
+		remotePaths = append(remotePaths, remoteDir)
+	}
+	return remotePaths, nil
+}
+
+// uploadPlaybookPath uploads a directory referenced by a playbook and returns its remote path.
+// Directories inside of the project_root have been uploaded with the project.
+func (v *RemoteMode) uploadPlaybookPath(playbook *types.Playbook, kind string, path string, playbookDir string, remotePlaybookDir string) (string, error) {
+	resolvedPath, err := types.ResolvePath(path)
+	if err != nil {
+		return "", err
+	}
+	if playbook.ProjectRoot() != "" {
+		if relPath, ok := pathInsideDirectory(playbookDir, resolvedPath); ok {
+			return filepath.Join(remotePlaybookDir, relPath), nil
+		}
+	}
+	v.o.Output(fmt.Sprintf("Uploading %s path '%s'...", kind, resolvedPath))
+	return v.uploadDirContentAddressed(resolvedPath, playbook.UploadExclude())
+}
+
 func (v *RemoteMode) installAnsible(remoteSettings *types.RemoteSettings) error {
 
-	var installerScript *bufio.Reader
+	var installerScript []byte
 	if remoteSettings.LocalInstallerPath() != "" {
 
 		cleanInstallerPath := filepath.Clean(remoteSettings.LocalInstallerPath())
-		file, err := os.Open(cleanInstallerPath)
+		script, err := ioutil.ReadFile(cleanInstallerPath)
 		if err != nil {
 			return err
 		}
-		defer file.Close()
 
 		v.o.Output(fmt.Sprintf("Installing Ansible using provided installer '%s'...", cleanInstallerPath))
 
-		installerScript = bufio.NewReader(file)
+		installerScript = script
 
 	} else {
 
-		embeddedInstaller := &ansibleInstaller{
-			AnsibleVersion: "ansible",
+		embeddedInstaller := newAnsibleInstaller(remoteSettings)
+
+		installed, err := v.checkInstallMarker(embeddedInstaller)
+		if err != nil {
+			return err
+		}
+		if installed {
+			v.o.Output(fmt.Sprintf("Ansible '%s' installed using '%s' is recorded in '%s', skipping installer.",
+				embeddedInstaller.spec(), embeddedInstaller.Method, embeddedInstaller.MarkerPath))
+			return nil
 		}
 
-		if remoteSettings.InstallVersion() != "" {
-			embeddedInstaller.AnsibleVersion = fmt.Sprintf("%s==%s",
-				embeddedInstaller.AnsibleVersion,
-				remoteSettings.InstallVersion())
+		if remoteSettings.OfflineBundle() != "" {
+			remoteBundle, err := v.uploadOfflineBundle(remoteSettings.OfflineBundle())
+			if err != nil {
+				return err
+			}
+			embeddedInstaller.OfflineBundle = remoteBundle
 		}
 
-		v.o.Output(fmt.Sprintf("Installing Ansible '%s' using default installer...", embeddedInstaller.AnsibleVersion))
+		v.o.Output(fmt.Sprintf("Installing Ansible '%s' using default installer, method '%s'...",
+			embeddedInstaller.spec(), embeddedInstaller.Method))
 
 		t := template.Must(template.New("installer").Parse(installerProgramTemplate))
 		var buf bytes.Buffer
-		err := t.Execute(&buf, embeddedInstaller)
-		if err != nil {
+		if err := t.Execute(&buf, embeddedInstaller); err != nil {
 			return fmt.Errorf("Error executing 'installer' template: %s", err)
 		}
-		installerScript = bufio.NewReader(bytes.NewReader(buf.Bytes()))
+		installerScript = buf.Bytes()
 	}
 
-	if err := v.runCommandNoSudo(fmt.Sprintf("mkdir -p \"%s\"",
-		filepath.Dir(remoteSettings.RemoteInstallerPath()))); err != nil {
+	if err := v.prepareInstallerDirectory(); err != nil {
 		return err
 	}
 
 	v.o.Output(fmt.Sprintf("Uploading Ansible installer program to '%s'...", remoteSettings.RemoteInstallerPath()))
-	if err := v.comm.UploadScript(remoteSettings.RemoteInstallerPath(), installerScript); err != nil {
+	if err := v.comm.Upload(remoteSettings.RemoteInstallerPath(), bytes.NewReader(installerScript)); err != nil {
 		return err
 	}
 
-	if err := v.runCommandSudo(fmt.Sprintf("/bin/sh -c '\"%s\" && rm \"%s\"'",
-		remoteSettings.RemoteInstallerPath(),
+	if err := v.runCommandSudo(installerCommand(installerInterpreter(installerScript),
+		remoteSettings.RemoteInstallerDirectory(),
 		remoteSettings.RemoteInstallerPath())); err != nil {
 		return err
 	}
@@ -436,7 +716,187 @@ func (v *RemoteMode) installAnsible(remoteSettings *types.RemoteSettings) error
 	return nil
 }
 
-func (v *RemoteMode) uploadVaultPasswordOrIDFile(destination string, source string) (string, error) {
+// prepareInstallerDirectory creates the installer directory. When the directory does not allow executing programs,
+// a directory in the home directory of the connection user is used instead, programs the installer writes
+// to its TMPDIR and runs would fail otherwise.
+func (v *RemoteMode) prepareInstallerDirectory() error {
+	dir := v.remoteSettings.RemoteInstallerDirectory()
+	if err := v.fs.MkdirAll(dir); err != nil {
+		return err
+	}
+	_, err := v.runCommandOutput(executableDirectoryCommand(dir))
+	if err == nil {
+		return nil
+	}
+	status, ok := remoteExitStatus(err)
+	if ok && status == directoryNotWritableExitCode {
+		return fmt.Errorf("the installer directory '%s' is not writable by the connection user", dir)
+	}
+	if !ok || status != directoryNoExecExitCode {
+		return err
+	}
+
+	home, err := v.runCommandOutput("printf '%s' \"$HOME\"")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(home) == "" {
+		return fmt.Errorf("the installer directory '%s' does not allow executing programs and the home directory of '%s' is unknown", dir, v.connInfo.User)
+	}
+	fallbackDir := filepath.Join(strings.TrimSpace(home), remoteInstallerHomeDirectory)
+	v.o.Output(fmt.Sprintf("The installer directory '%s' does not allow executing programs, likely mounted noexec, using '%s' instead.", dir, fallbackDir))
+	if err := v.fs.MkdirAll(fallbackDir); err != nil {
+		return err
+	}
+	if _, err := v.runCommandOutput(executableDirectoryCommand(fallbackDir)); err != nil {
+		if _, ok := remoteExitStatus(err); ok {
+			return fmt.Errorf("neither the installer directory '%s' nor '%s' allow executing programs, choose another remote.remote_installer_directory", dir, fallbackDir)
+		}
+		return err
+	}
+	v.remoteSettings.SetOverrideRemoteInstallerDirectory(fallbackDir)
+	return nil
+}
+
+// installerCommand returns a command running the installer with the interpreter, the installer does not need
+// to be executable, and removing it afterwards. The installer directory is the TMPDIR of the installer.
+func installerCommand(interpreter string, dir string, path string) string {
+	return fmt.Sprintf("/bin/sh -c 'TMPDIR=\"%s\" %s \"%s\"; status=$?; rm -f \"%s\"; exit $status'", dir, interpreter, path, path)
+}
+
+// installerInterpreter returns the interpreter of the installer shebang line, sh without one.
+func installerInterpreter(script []byte) string {
+	firstLine := strings.SplitN(string(script), "\n", 2)[0]
+	if !strings.HasPrefix(firstLine, "#!") {
+		return "sh"
+	}
+	interpreter := strings.TrimSpace(strings.TrimPrefix(firstLine, "#!"))
+	// the interpreter is given inside of a single quoted command:
+	if interpreter == "" || strings.ContainsAny(interpreter, "'\"\\$`;&|<>") {
+		return "sh"
+	}
+	return interpreter
+}
+
+func newAnsibleInstaller(remoteSettings *types.RemoteSettings) *ansibleInstaller {
+	method := remoteSettings.InstallMethod()
+	if remoteSettings.OfflineBundle() != "" {
+		method = remoteInstallMethodOffline
+	}
+	return &ansibleInstaller{
+		Method:     method,
+		Package:    remoteSettings.InstallPackage(),
+		Version:    remoteSettings.InstallVersion(),
+		MarkerPath: remoteInstallMarkerPath,
+		VenvPath:   remoteInstallVenvPath,
+		PipxHome:   remoteInstallPipxHome,
+		BinDir:     remoteInstallBinDir,
+	}
+}
+
+// uploadOfflineBundle uploads the wheels of a local directory or a .tar.gz / .tgz file to the bootstrap directory
+// and returns the remote directory. The upload is verified against the local checksums before it is used.
+func (v *RemoteMode) uploadOfflineBundle(bundle string) (string, error) {
+	resolvedBundle, err := types.ResolvePath(bundle)
+	if err != nil {
+		return "", err
+	}
+	if err := v.fs.MkdirAll(v.remoteSettings.BootstrapDirectory()); err != nil {
+		return "", err
+	}
+	if _, err := types.ResolveDirectory(resolvedBundle); err == nil {
+		v.o.Output(fmt.Sprintf("Uploading the offline bundle directory '%s'...", resolvedBundle))
+		return v.uploadDirContentAddressed(resolvedBundle, []string{})
+	}
+
+	digest, err := fileSHA256(resolvedBundle)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(resolvedBundle)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	remoteDir := filepath.Join(v.remoteSettings.BootstrapDirectory(), fmt.Sprintf("offline-bundle-%s", digest))
+	remoteArchivePath := fmt.Sprintf("%s.tar.gz", remoteDir)
+	v.o.Output(fmt.Sprintf("Uploading the offline bundle '%s', sha256 %s...", resolvedBundle, digest))
+	if err := v.comm.Upload(remoteArchivePath, bufio.NewReader(file)); err != nil {
+		return "", err
+	}
+	remoteDigest, err := v.fs.SHA256(remoteArchivePath)
+	if err != nil {
+		return "", err
+	}
+	if remoteDigest != digest {
+		v.fs.Remove(remoteArchivePath)
+		return "", fmt.Errorf("the offline bundle uploaded to '%s' has sha256 %s, expected %s", remoteArchivePath, remoteDigest, digest)
+	}
+	if err := v.runCommandNoSudo(unpackArchiveCommand(digest, remoteArchivePath, remoteDir)); err != nil {
+		return "", fmt.Errorf("failed verifying and unpacking the offline bundle '%s': %v", remoteArchivePath, err)
+	}
+	return remoteDir, nil
+}
+
+func (i *ansibleInstaller) spec() string {
+	if i.Version == "" {
+		return i.Package
+	}
+	return fmt.Sprintf("%s==%s", i.Package, i.Version)
+}
+
+// markerCheckCommand returns a command exiting with remoteInstallMarkerExitCode when the marker
+// records the requested installation method, package and version and ansible-playbook is available.
+// A version matches a more specific installed version, 2.9 matches 2.9.27.
+func (i *ansibleInstaller) markerCheckCommand() string {
+	recorded := fmt.Sprintf("\"%s %s==\"*", i.Method, i.Package)
+	if i.Version != "" {
+		expected := fmt.Sprintf("\"%s %s==%s\"", i.Method, i.Package, i.Version)
+		recorded = fmt.Sprintf("%s|%s.*|%s-*", expected, expected, expected)
+	}
+	return fmt.Sprintf("/bin/sh -c 'case \"$(cat \"%s\" 2>/dev/null)\" in %s) command -v ansible-playbook >/dev/null 2>&1 && exit %d;; esac; exit 0'",
+		i.MarkerPath,
+		recorded,
+		remoteInstallMarkerExitCode)
+}
+
+// checkInstallMarker returns true when the requested Ansible installation is recorded on the host.
+func (v *RemoteMode) checkInstallMarker(installer *ansibleInstaller) (bool, error) {
+	if err := v.runCommandNoSudo(installer.markerCheckCommand()); err != nil {
+		if status, ok := remoteExitStatus(err); ok && status == remoteInstallMarkerExitCode {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// uploadVaultFiles uploads the Vault IDs or the Vault password file of the play to the destination
+// and points the play to the uploaded files.
+func (v *RemoteMode) uploadVaultFiles(play *types.Play, destination string) error {
+	if len(play.VaultID()) > 0 {
+		overrideVaultIDs := make([]string, 0)
+		for _, vaultID := range play.VaultID() {
+			uploadedVaultIDPath, err := v.uploadVaultPasswordOrIDFile(play, destination, vaultID)
+			if err != nil {
+				return err
+			}
+			if uploadedVaultIDPath != "" {
+				overrideVaultIDs = append(overrideVaultIDs, uploadedVaultIDPath)
+			}
+		}
+		play.SetOverrideVaultID(overrideVaultIDs)
+		return nil
+	}
+	uploadedVaultPasswordFilePath, err := v.uploadVaultPasswordOrIDFile(play, destination, play.VaultPasswordFile())
+	if err != nil {
+		return err
+	}
+	play.SetOverrideVaultPasswordPath(uploadedVaultPasswordFilePath)
+	return nil
+}
+
+func (v *RemoteMode) uploadVaultPasswordOrIDFile(play *types.Play, destination string, source string) (string, error) {
 
 	if source == "" {
 		return "", nil
@@ -462,6 +922,7 @@ func (v *RemoteMode) uploadVaultPasswordOrIDFile(destination string, source stri
 		return "", err
 	}
 
+	v.addSensitiveFile(play, targetPath)
 	v.o.Output("Ansible vault password file uploaded.")
 
 	return targetPath, nil
@@ -522,40 +983,231 @@ func (v *RemoteMode) writeInventory(destination string, play *types.Play) (strin
 
 }
 
+// cleanupAfterBootstrap removes the bootstrap directory of the run. The upload cache is kept, later runs reuse it.
 func (v *RemoteMode) cleanupAfterBootstrap() {
 	v.o.Output("Cleaning up after bootstrap...")
-	v.runCommandNoSudo(fmt.Sprintf("rm -rf \"%s\"", v.remoteSettings.BootstrapDirectory()))
+	if err := v.fs.Remove(v.remoteSettings.BootstrapDirectory()); err != nil {
+		v.o.Output(fmt.Sprintf("Failed removing '%s': %v", v.remoteSettings.BootstrapDirectory(), err))
+	}
 	v.o.Output("Cleanup complete.")
 }
 
-func (v *RemoteMode) checkRemoteDirExists(remoteDir string) (bool, error) {
-	magicErrorCode := 50
-	command := fmt.Sprintf("/bin/sh -c 'if [ -d \"%s\" ]; then exit %d; fi'", remoteDir, magicErrorCode)
-	if err := v.runCommandNoSudo(command); err != nil {
-		if strings.Contains(err.Error(), fmt.Sprintf("exited with non-zero exit status: %d,", magicErrorCode)) {
-			// we have found the exact match of the magic error,
-			// directory exists
-			return true, nil
+// runCommandOutput runs a command without sudo and returns its standard output.
+func (v *RemoteMode) runCommandOutput(command string) (string, error) {
+	var stdout bytes.Buffer
+	errR, errW := io.Pipe()
+	errDoneCh := make(chan struct{})
+	go v.copyOutput(errR, errDoneCh)
+
+	cmd := &remote.Cmd{
+		Command: command,
+		Stdout:  &stdout,
+		Stderr:  errW,
+	}
+
+	err := v.comm.Start(cmd)
+	if err != nil {
+		return "", fmt.Errorf("Error executing command %q: %v", cmd.Command, err)
+	}
+
+	err = cmd.Wait()
+	if err != nil {
+		if exitErr, ok := err.(*remote.ExitError); ok {
+			err = &remoteCommandError{
+				command:    cmd.Command,
+				exitStatus: exitErr.ExitStatus,
+				err:        exitErr.Err,
+			}
+		} else {
+			err = fmt.Errorf(
+				"Command '%q' failed, reason: %+v", cmd.Command, err)
 		}
-		return false, err
 	}
-	return false, nil
+
+	errW.Close()
+	<-errDoneCh
+
+	return stdout.String(), err
+}
+
+// prepareBootstrapDirectory creates the private 0700 bootstrap directory of the run. The directory is named
+// randomly and created without -p, it can not exist already. With upload_cache, the uploads are kept in the
+// stable directory, it is reused only when it is a directory, not a symbolic link, owned by the connection user.
+func (v *RemoteMode) prepareBootstrapDirectory() error {
+	if v.remoteSettings.UploadCache() {
+		dir := v.remoteSettings.StableBootstrapDirectory()
+		if err := v.runCommandNoSudo(stableBootstrapDirectoryCommand(dir)); err != nil {
+			if status, ok := remoteExitStatus(err); ok && status == bootstrapDirectoryNotOwnedExitCode {
+				return fmt.Errorf("bootstrap directory '%s' is not a directory owned by '%s', not reusing it", dir, v.connInfo.User)
+			}
+			return err
+		}
+		v.o.Output(fmt.Sprintf("Using the upload cache '%s'.", dir))
+	}
+	dir := fmt.Sprintf("%s-%s", v.remoteSettings.StableBootstrapDirectory(), uuid.NewV4())
+	if err := v.fs.MkdirAll(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	if err := v.fs.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+	v.remoteSettings.SetOverrideBootstrapDirectory(dir)
+	v.o.Output(fmt.Sprintf("Using the bootstrap directory '%s'.", dir))
+	return nil
+}
+
+// uploadDirectory returns the directory of the content addressed uploads, the stable bootstrap directory
+// with upload_cache, the bootstrap directory of the run otherwise.
+func (v *RemoteMode) uploadDirectory() string {
+	if v.remoteSettings.UploadCache() {
+		return v.remoteSettings.StableBootstrapDirectory()
+	}
+	return v.remoteSettings.BootstrapDirectory()
+}
+
+// stableBootstrapDirectoryCommand returns a command creating the private directory, if necessary,
+// exiting with bootstrapDirectoryNotOwnedExitCode when an existing path can not be trusted.
+func stableBootstrapDirectoryCommand(dir string) string {
+	return fmt.Sprintf("/bin/sh -c 'mkdir -p -m 0700 \"%s\" && if [ -L \"%s\" ] || [ ! -d \"%s\" ] || [ ! -O \"%s\" ]; then exit %d; fi && chmod 0700 \"%s\"'",
+		dir, dir, dir, dir, bootstrapDirectoryNotOwnedExitCode, dir)
+}
+
+// uploadNetworkCABundle uploads the CA bundle of the network settings, if any, to the bootstrap directory.
+func (v *RemoteMode) uploadNetworkCABundle() error {
+	if v.remoteSettings.Network().CABundle() == "" {
+		return nil
+	}
+	caBundle, err := types.ResolvePath(v.remoteSettings.Network().CABundle())
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(caBundle)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := v.fs.MkdirAll(v.remoteSettings.BootstrapDirectory()); err != nil {
+		return err
+	}
+	remoteCABundle := filepath.Join(v.remoteSettings.BootstrapDirectory(), "network-ca-bundle.pem")
+	v.o.Output(fmt.Sprintf("Uploading the CA bundle '%s' to '%s'...", caBundle, remoteCABundle))
+	if err := v.comm.Upload(remoteCABundle, bufio.NewReader(file)); err != nil {
+		return err
+	}
+	v.remoteCABundle = remoteCABundle
+	return nil
+}
+
+// becomeCommand prefixes the command with the network environment and, unless prevented, with the become command.
+// The environment is given with env, sudo would otherwise reset it.
+func (v *RemoteMode) becomeCommand(command string) string {
+	env := v.remoteSettings.Network().Environment(v.remoteCABundle)
+	if len(env) > 0 {
+		names := make([]string, 0)
+		for name := range env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		assignments := make([]string, 0)
+		for _, name := range names {
+			assignments = append(assignments, fmt.Sprintf("%s='%s'", name, shellescape.NewSingleQuoteEscape(env[name]).Safe()))
+		}
+		command = fmt.Sprintf("env %s %s", strings.Join(assignments, " "), command)
+	}
+	if !v.remoteSettings.UseSudo() {
+		return command
+	}
+	user := v.remoteSettings.BecomeUser()
+	switch v.remoteSettings.BecomeCommand() {
+	case types.RemoteBecomeSu:
+		if user == "" {
+			user = "root"
+		}
+		return fmt.Sprintf("su '%s' -c '%s'", shellescape.NewSingleQuoteEscape(user).Safe(), shellescape.NewSingleQuoteEscape(command).Safe())
+	case types.RemoteBecomeDoas, types.RemoteBecomePbrun:
+		// neither takes variable assignments before the command:
+		if !strings.HasPrefix(command, "env ") {
+			command = fmt.Sprintf("env %s", command)
+		}
+		if user != "" {
+			command = fmt.Sprintf("-u '%s' %s", shellescape.NewSingleQuoteEscape(user).Safe(), command)
+		}
+		return fmt.Sprintf("%s %s", v.remoteSettings.BecomeCommand(), command)
+	}
+	if v.remoteSettings.BecomePassword() != "" {
+		// the password is given over stdin, never on the command line. sudo does not prompt without a password
+		// required or with cached credentials, the command gets no input and never reads the password:
+		command = fmt.Sprintf("/bin/sh -c 'exec < /dev/null; %s'", shellescape.NewSingleQuoteEscape(command).Safe())
+	}
+	if user != "" {
+		command = fmt.Sprintf("-u '%s' %s", shellescape.NewSingleQuoteEscape(user).Safe(), command)
+	}
+	if v.remoteSettings.BecomePassword() != "" {
+		command = fmt.Sprintf("-S -k -p '' %s", command)
+	}
+	return fmt.Sprintf("sudo %s", command)
+}
+
+// becomeStdin returns the input of a become command, the sudo password when given.
+func (v *RemoteMode) becomeStdin() io.Reader {
+	if !v.remoteSettings.UseSudo() || v.remoteSettings.BecomePassword() == "" {
+		return nil
+	}
+	return strings.NewReader(fmt.Sprintf("%s\n", v.remoteSettings.BecomePassword()))
 }
 
 func (v *RemoteMode) runCommandSudo(command string) error {
 	return v.runCommand(command, true)
 }
 
+// runPlayCommand runs the command of a play with the become command,
+// in a session forwarding the local SSH agent when remote.forward_agent is set.
+func (v *RemoteMode) runPlayCommand(dir string, command string, logPath string) error {
+	var starter commandStarter = v.comm
+	if v.agentForwarding != nil {
+		starter = v.agentForwarding
+	}
+	return v.startCommand(starter, v.playCommand(dir, command, logPath), v.becomeStdin())
+}
+
+// playCommand returns the command of a play with the become command, running in the given working directory
+// unless empty, the become command keeps the working directory. Ansible logs to the log path unless empty.
+// With remote.forward_agent, SSH_AUTH_SOCK of the session is passed through the become command.
+func (v *RemoteMode) playCommand(dir string, command string, logPath string) string {
+	assignments := make([]string, 0)
+	if logPath != "" {
+		assignments = append(assignments, fmt.Sprintf("ANSIBLE_LOG_PATH=\"%s\"", logPath))
+	}
+	if v.remoteSettings.ForwardAgent() {
+		assignments = append(assignments, "SSH_AUTH_SOCK=\"$SSH_AUTH_SOCK\"")
+	}
+	if len(assignments) > 0 {
+		command = fmt.Sprintf("env %s %s", strings.Join(assignments, " "), command)
+	}
+	command = v.becomeCommand(command)
+	if dir != "" {
+		command = fmt.Sprintf("cd \"%s\" && %s", dir, command)
+	}
+	return command
+}
+
 func (v *RemoteMode) runCommandNoSudo(command string) error {
 	return v.runCommand(command, false)
 }
 
 func (v *RemoteMode) runCommand(command string, shouldSudo bool) error {
-	// Unless prevented, prefix the command with sudo
-	if shouldSudo && v.remoteSettings.UseSudo() {
-		command = fmt.Sprintf("sudo %s", command)
+	// Unless prevented, prefix the command with the become command
+	if shouldSudo {
+		return v.execCommand(v.becomeCommand(command), v.becomeStdin())
 	}
+	return v.execCommand(command, nil)
+}
 
+func (v *RemoteMode) execCommand(command string, stdin io.Reader) error {
+	return v.startCommand(v.comm, command, stdin)
+}
+
+func (v *RemoteMode) startCommand(starter commandStarter, command string, stdin io.Reader) error {
 	outR, outW := io.Pipe()
 	errR, errW := io.Pipe()
 	outDoneCh := make(chan struct{})
@@ -565,11 +1217,12 @@ func (v *RemoteMode) runCommand(command string, shouldSudo bool) error {
 
 	cmd := &remote.Cmd{
 		Command: command,
+		Stdin:   stdin,
 		Stdout:  outW,
 		Stderr:  errW,
 	}
 
-	err := v.comm.Start(cmd)
+	err := starter.Start(cmd)
 	if err != nil {
 		return fmt.Errorf("Error executing command %q: %v", cmd.Command, err)
 	}
@@ -577,8 +1230,11 @@ func (v *RemoteMode) runCommand(command string, shouldSudo bool) error {
 	err = cmd.Wait()
 	if err != nil {
 		if exitErr, ok := err.(*remote.ExitError); ok {
-			err = fmt.Errorf(
-				"Command '%q' exited with non-zero exit status: %d, reason %+v", cmd.Command, exitErr.ExitStatus, exitErr.Err)
+			err = &remoteCommandError{
+				command:    cmd.Command,
+				exitStatus: exitErr.ExitStatus,
+				err:        exitErr.Err,
+			}
 		} else {
 			err = fmt.Errorf(
 				"Command '%q' failed, reason: %+v", cmd.Command, err)
@@ -594,6 +1250,41 @@ func (v *RemoteMode) runCommand(command string, shouldSudo bool) error {
 	return err
 }
 
+// remoteCommandError is returned for a remote command exiting with a non-zero status.
+type remoteCommandError struct {
+	command    string
+	exitStatus int
+	err        error
+}
+
+func (e *remoteCommandError) Error() string {
+	return fmt.Sprintf("Command '%q' exited with non-zero exit status: %d, reason %+v", e.command, e.exitStatus, e.err)
+}
+
+// remoteExitStatus returns the exit status of a remote command which exited with a non-zero status.
+func remoteExitStatus(err error) (int, bool) {
+	var commandErr *remoteCommandError
+	if errors.As(err, &commandErr) {
+		return commandErr.exitStatus, true
+	}
+	return 0, false
+}
+
+// openRemoteFS returns the filesystem of the host over SFTP or, when the server does not offer SFTP,
+// over shell commands.
+func (v *RemoteMode) openRemoteFS() remoteFS {
+	shell := &shellRemoteFS{
+		run:    v.runCommandNoSudo,
+		output: v.runCommandOutput,
+	}
+	fs, err := newSFTPRemoteFS(v.connInfo, shell)
+	if err != nil {
+		v.o.Output(fmt.Sprintf("SFTP not available (%v), using shell commands for remote files.", err))
+		return shell
+	}
+	return fs
+}
+
 func (v *RemoteMode) copyOutput(r io.Reader, doneCh chan<- struct{}) {
 	defer close(doneCh)
 	lr := linereader.New(r)
@@ -3,6 +3,7 @@ package mode
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 	"sync"
@@ -47,11 +48,7 @@ func TestIntegrationRemoteModeProvisioning(t *testing.T) {
 	testModuleName := "ping"
 
 	remoteSettingsRaw := map[string]interface{}{
-		"skip_install":               false,
-		"use_sudo":                   true,
-		"skip_cleanup":               false,
 		"install_version":            "ansible@integration-test",
-		"local_installer_path":       "",
 		"remote_installer_directory": remoteTempDirectory,
 		"bootstrap_directory":        bootstrapDirectory,
 	}
@@ -72,7 +69,7 @@ func TestIntegrationRemoteModeProvisioning(t *testing.T) {
 	defer os.RemoveAll(tempAnsibleDataDir)
 	playbookFilePath := test.WriteTempPlaybook(t, tempAnsibleDataDir)
 
-	remoteSettings := test.GetNewRemoteSettings(t, remoteSettingsRaw)
+	remoteSettings := test.GetNewRemoteSettingsWithDefaults(t, remoteSettingsRaw)
 	defaultSettings := test.GetDefaultSettingsForUser(t, user)
 	playModuleRawConfigs := test.GetPlayModuleSchema(t, testModuleName)
 	playPlaybookRawConfigs := test.GetPlayPlaybookSchema(t, playbookFilePath)
@@ -137,35 +134,56 @@ func TestIntegrationRemoteModeProvisioning(t *testing.T) {
 		}
 	}()
 
+	// the testing server offers SFTP, directories are created and removed without commands.
+
+	// preflight checks:
+	test.CommandTest(t, sshServer, "/bin/sh -c 'probe=")
+	test.CommandTest(t, sshServer, fmt.Sprintf("df -Pk \"%s", bootstrapDirectory))
+	test.CommandTest(t, sshServer, "sudo -n true")
+	test.CommandTest(t, sshServer, "command -v python3")
+	test.CommandTest(t, sshServer, "command -v ansible-playbook")
+
 	// upload ansible data for th first play:
-	test.CommandTest(t, sshServer, fmt.Sprintf("mkdir -p \"%s", bootstrapDirectory))
 	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", bootstrapDirectory))
 	// upload vault ID for the first play:
 	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", bootstrapDirectory))
 
 	// upload ansible data for the second play:
-	test.CommandTest(t, sshServer, fmt.Sprintf("mkdir -p \"%s", bootstrapDirectory))
-	test.CommandTest(t, sshServer, "/bin/sh -c 'if [ -d") // playbook always checks if we have the source playbook dir uploaded
-	test.CommandTest(t, sshServer, fmt.Sprintf("scp -rvt %s", bootstrapDirectory))
+	test.CommandTest(t, sshServer, "/bin/sh -c 'if [ -f")                         // playbook always verifies the content addressed upload
+	test.CommandTest(t, sshServer, fmt.Sprintf("cat \"%s", bootstrapDirectory))   // previous upload of the directory
+	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", bootstrapDirectory)) // upload archive
+	test.CommandTest(t, sshServer, "/bin/sh -c 'echo")                            // verify and unpack archive
+	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", bootstrapDirectory)) // upload manifest
+	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", bootstrapDirectory)) // latest upload pointer
 	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", bootstrapDirectory)) // an inventory is written
 	// upload vault ID for the second play:
 	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", bootstrapDirectory))
 
+	// check the installation marker:
+	test.CommandTest(t, sshServer, "/bin/sh -c 'case")
+	// the installer directory allows executing programs:
+	test.CommandTest(t, sshServer, "/bin/sh -c 'probe=")
 	// upload installer:
-	test.CommandTest(t, sshServer, fmt.Sprintf("mkdir -p \"%s", remoteTempDirectory))
 	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", remoteTempDirectory))
-	// make the installer executable:
-	test.CommandTest(t, sshServer, "chmod 0777")
 	// run and cleanup ansible installer:
-	test.CommandTest(t, sshServer, "sudo /bin/sh -c")
+	test.CommandTest(t, sshServer, fmt.Sprintf("sudo /bin/sh -c 'TMPDIR=\"%s\" /bin/sh \"%s/tf-ansible-installer\"", remoteTempDirectory, remoteTempDirectory))
 
 	// run ansible module:
 	test.CommandTest(t, sshServer, fmt.Sprintf("sudo ANSIBLE_FORCE_COLOR=true ansible all --module-name='%s'", testModuleName))
+	// the vault ID of the module play is removed right after the play:
+	test.CommandTest(t, sshServer, fmt.Sprintf("shred -u \"%s", bootstrapDirectory))
 	test.CommandTest(t, sshServer, "sudo ANSIBLE_FORCE_COLOR=true ansible-playbook")
-
-	// cleanup ansible data:
-	test.CommandTest(t, sshServer, fmt.Sprintf("rm -rf \"%s", bootstrapDirectory))
+	test.CommandTest(t, sshServer, fmt.Sprintf("shred -u \"%s", bootstrapDirectory))
 
 	wg.Wait()
 
+	// the bootstrap directory of the run is removed:
+	entries, err := ioutil.ReadDir(bootstrapDirectory)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) > 0 {
+		t.Fatalf("Expected the bootstrap directory of the run to be removed but found '%s'", entries[0].Name())
+	}
+
 }
@@ -0,0 +1,51 @@
+package mode
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/radekg/terraform-provisioner-ansible/v2/types"
+)
+
+// resolvePlaybookDirectory returns the directory uploaded with the playbook and the path of the playbook
+// relative to it. The directory is the project_root, when given, otherwise the parent directory of the playbook.
+func resolvePlaybookDirectory(playbook *types.Playbook) (string, string, error) {
+	playbookPath, err := types.ResolvePath(playbook.FilePath())
+	if err != nil {
+		return "", "", err
+	}
+	playbookPath, err = filepath.Abs(playbookPath)
+	if err != nil {
+		return "", "", err
+	}
+	if playbook.ProjectRoot() == "" {
+		return filepath.Dir(playbookPath), filepath.Base(playbookPath), nil
+	}
+	projectRoot, err := types.ResolveDirectory(playbook.ProjectRoot())
+	if err != nil {
+		return "", "", err
+	}
+	projectRoot, err = filepath.Abs(projectRoot)
+	if err != nil {
+		return "", "", err
+	}
+	relPath, ok := pathInsideDirectory(projectRoot, playbookPath)
+	if !ok {
+		return "", "", fmt.Errorf("playbook '%s' is not inside the project_root '%s'", playbook.FilePath(), playbook.ProjectRoot())
+	}
+	return projectRoot, relPath, nil
+}
+
+// pathInsideDirectory returns the path relative to the absolute directory, if the path is inside of it.
+func pathInsideDirectory(dir string, path string) (string, bool) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	relPath, err := filepath.Rel(dir, absPath)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return relPath, true
+}
@@ -0,0 +1,66 @@
+package mode
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/radekg/terraform-provisioner-ansible/v2/types"
+)
+
+func getTestPlaybook(t *testing.T, filePath string, projectRoot string) *types.Playbook {
+	raw := map[string]interface{}{
+		"playbook": []interface{}{
+			map[string]interface{}{
+				"file_path":    filePath,
+				"project_root": projectRoot,
+			},
+		},
+	}
+	data := schema.TestResourceDataRaw(t, map[string]*schema.Schema{
+		"playbook": types.NewPlaybookSchema(),
+	}, raw)
+	return types.NewPlaybookFromInterface(data.Get("playbook"))
+}
+
+func TestResolvePlaybookDirectory(t *testing.T) {
+	project := writeTestUploadDir(t, map[string]string{
+		"playbooks/site.yml":     "- import_playbook: ../common.yml\n",
+		"common.yml":             "- hosts: all\n",
+		"group_vars/all/all.yml": "setting: 1\n",
+	})
+	defer os.RemoveAll(project)
+	playbookPath := filepath.Join(project, "playbooks", "site.yml")
+
+	dir, relPath, err := resolvePlaybookDirectory(getTestPlaybook(t, playbookPath, ""))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if dir != filepath.Join(project, "playbooks") || relPath != "site.yml" {
+		t.Fatalf("Expected the parent directory of the playbook but got '%s', '%s'", dir, relPath)
+	}
+
+	dir, relPath, err = resolvePlaybookDirectory(getTestPlaybook(t, playbookPath, project))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if dir != project || relPath != filepath.Join("playbooks", "site.yml") {
+		t.Fatalf("Expected the project root but got '%s', '%s'", dir, relPath)
+	}
+
+	if _, _, err := resolvePlaybookDirectory(getTestPlaybook(t, playbookPath, filepath.Join(project, "group_vars"))); err == nil {
+		t.Fatal("Expected an error for a playbook outside of the project root")
+	}
+}
+
+func TestPathInsideDirectory(t *testing.T) {
+	if relPath, ok := pathInsideDirectory("/project", "/project/roles"); !ok || relPath != "roles" {
+		t.Fatalf("Expected '/project/roles' inside of '/project' but got '%s'", relPath)
+	}
+	for _, path := range []string{"/project-roles", "/roles", "/project/../roles"} {
+		if _, ok := pathInsideDirectory("/project", path); ok {
+			t.Fatalf("Expected '%s' not inside of '/project'", path)
+		}
+	}
+}
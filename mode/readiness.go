@@ -0,0 +1,120 @@
+package mode
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/radekg/terraform-provisioner-ansible/v2/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// cloudInitBootFinished succeeds when the host does not use cloud-init or cloud-init has finished booting it.
+const cloudInitBootFinished = `[ ! -d /var/lib/cloud/instance ] || [ -f /var/lib/cloud/instance/boot-finished ]`
+
+const readinessInitialBackoff = time.Second
+
+// waitForTargets waits until every target accepts an SSH connection, optionally through the bastion,
+// and, if requested, until cloud-init has finished on the compute resource host. Targets are checked one after another
+// within a single timeout, the delay between attempts doubles up to the maximum backoff.
+func (v *LocalMode) waitForTargets(targets []*sshTarget, knownHostsFile string, ansibleSSHSettings *types.AnsibleSSHSettings) error {
+	deadline := time.Now().Add(time.Duration(ansibleSSHSettings.ReadinessTimeoutSeconds()) * time.Second)
+	maxBackoff := time.Duration(ansibleSSHSettings.ReadinessMaxBackoffSeconds()) * time.Second
+
+	command := "exit 0"
+	if ansibleSSHSettings.WaitForCloudInit() {
+		command = cloudInitBootFinished
+	}
+
+	for _, target := range targets {
+		v.o.Output(fmt.Sprintf("waiting for '%s' to become ready...", target.alias))
+		backoff := readinessInitialBackoff
+		for {
+			err := v.checkTargetReady(target, command, knownHostsFile, ansibleSSHSettings)
+			if err == nil {
+				v.o.Output(fmt.Sprintf("'%s' is ready", target.alias))
+				break
+			}
+			if time.Now().Add(backoff).After(deadline) {
+				return fmt.Errorf("host '%s' not ready within %d seconds, last error: %v",
+					target.alias,
+					ansibleSSHSettings.ReadinessTimeoutSeconds(),
+					err)
+			}
+			v.o.Output(fmt.Sprintf("'%s' not ready yet (last error: %v); retrying in %s...", target.alias, err, backoff))
+			time.Sleep(backoff)
+			backoff = backoff * 2
+			if maxBackoff > 0 && backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+	}
+	return nil
+}
+
+// checkTargetReady opens an SSH session to the target with the connection credentials and runs the command,
+// the target is ready when the command succeeds. The connection credentials apply only to the compute resource
+// host, any other target is ready once it completes the SSH handshake with a verified host key.
+func (v *LocalMode) checkTargetReady(target *sshTarget, command string, knownHostsFile string, ansibleSSHSettings *types.AnsibleSSHSettings) error {
+	targetHost := newTargetHostFromConnectionInfo(v.connInfo)
+	sshConfig := &ssh.ClientConfig{User: v.connInfo.User}
+	if target.credentials {
+		configurator := &sshConfigurator{
+			provider: targetHost,
+		}
+		var err error
+		sshConfig, err = configurator.sshConfig()
+		if err != nil {
+			return err
+		}
+		if v.connInfo.Password != "" {
+			sshConfig.Auth = append(sshConfig.Auth, ssh.Password(v.connInfo.Password))
+		}
+	}
+	sshConfig.Timeout = time.Duration(ansibleSSHSettings.ConnectTimeoutSeconds()) * time.Second
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if !ansibleSSHSettings.InsecureNoStrictHostKeyChecking() {
+		if ansibleSSHSettings.UserKnownHostsFile() != "" {
+			knownHostsFile = ansibleSSHSettings.UserKnownHostsFile()
+		}
+		var err error
+		hostKeyCallback, err = knownhosts.New(knownHostsFile)
+		if err != nil {
+			return err
+		}
+	}
+	// authentication starts only after the host key has been verified:
+	hostKeyVerified := false
+	sshConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if err := hostKeyCallback(hostname, remote, key); err != nil {
+			return err
+		}
+		hostKeyVerified = true
+		return nil
+	}
+
+	clients, err := targetHost.dial(sshAddress(target.host, target.port), sshConfig)
+	if err != nil {
+		if !target.credentials && hostKeyVerified {
+			return nil
+		}
+		return err
+	}
+	defer func() {
+		for i := len(clients) - 1; i >= 0; i-- {
+			clients[i].Close()
+		}
+	}()
+	if !target.credentials {
+		return nil
+	}
+
+	session, err := clients[len(clients)-1].NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return session.Run(command)
+}
@@ -0,0 +1,113 @@
+package mode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+)
+
+func TestReadinessTargetReady(t *testing.T) {
+	instanceState := test.GetNewSSHInstanceState(t, "test-username")
+	output := new(terraform.MockUIOutput)
+	sshServer := test.GetConfiguredAndRunningSSHServer(t, "readiness", false, instanceState, output)
+	defer sshServer.Stop()
+
+	modeLocal, err := NewLocalMode(output, instanceState)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	port, _ := strconv.Atoi(instanceState.Ephemeral.ConnInfo["port"])
+	target := &sshTarget{alias: "ready-host", host: instanceState.Ephemeral.ConnInfo["host"], port: port, credentials: true}
+
+	knownHostsFile, err := ioutil.TempFile("", "readiness-known-hosts")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.Remove(knownHostsFile.Name())
+	fmt.Fprintf(knownHostsFile, "%s %s\n", target.knownHostsAddress(), test.TestSSHHostKeyPublic)
+	knownHostsFile.Close()
+
+	ansibleSSHSettings := test.GetNewAnsibleSSHSettings(t, map[string]interface{}{
+		"readiness_timeout":   10,
+		"wait_for_cloud_init": true,
+	})
+	if err := modeLocal.waitForTargets([]*sshTarget{target}, knownHostsFile.Name(), ansibleSSHSettings); err != nil {
+		t.Fatalf("Expected the target to be ready but got an error: %v", err)
+	}
+}
+
+func TestReadinessTargetWithoutCredentials(t *testing.T) {
+	instanceState := test.GetNewSSHInstanceState(t, "test-username")
+	output := new(terraform.MockUIOutput)
+	sshServer := test.GetConfiguredAndRunningSSHServer(t, "readiness-handshake", false, instanceState, output)
+	defer sshServer.Stop()
+
+	modeLocal, err := NewLocalMode(output, instanceState)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	port, _ := strconv.Atoi(instanceState.Ephemeral.ConnInfo["port"])
+	// an inventory host, the connection credentials do not apply:
+	target := &sshTarget{alias: "inventory-host", host: instanceState.Ephemeral.ConnInfo["host"], port: port}
+	ansibleSSHSettings := test.GetNewAnsibleSSHSettings(t, map[string]interface{}{
+		"readiness_timeout": 10,
+	})
+
+	writeKnownHosts := func(hostKey string) string {
+		knownHostsFile, err := ioutil.TempFile("", "readiness-known-hosts")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		fmt.Fprintf(knownHostsFile, "%s %s\n", target.knownHostsAddress(), hostKey)
+		knownHostsFile.Close()
+		return knownHostsFile.Name()
+	}
+
+	// the handshake completes, the host is reachable:
+	knownHostsFile := writeKnownHosts(test.TestSSHHostKeyPublic)
+	defer os.Remove(knownHostsFile)
+	if err := modeLocal.checkTargetReady(target, "exit 0", knownHostsFile, ansibleSSHSettings); err != nil {
+		t.Fatalf("Expected the target to be ready after the handshake but got an error: %v", err)
+	}
+
+	// a host key which does not verify is never ready:
+	otherKnownHostsFile := writeKnownHosts(test.TestSSHUserKeyPublic)
+	defer os.Remove(otherKnownHostsFile)
+	if err := modeLocal.checkTargetReady(target, "exit 0", otherKnownHostsFile, ansibleSSHSettings); err == nil {
+		t.Fatal("Expected a target with an unknown host key not to be ready")
+	}
+}
+
+func TestReadinessTargetNotReady(t *testing.T) {
+	// reserve a port nothing listens on:
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, p, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	instanceState := test.GetNewSSHInstanceState(t, "test-username")
+	instanceState.Ephemeral.ConnInfo["port"] = p
+	modeLocal, err := NewLocalMode(new(terraform.MockUIOutput), instanceState)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	port, _ := strconv.Atoi(p)
+
+	ansibleSSHSettings := test.GetNewAnsibleSSHSettings(t, map[string]interface{}{
+		"readiness_timeout":                    2,
+		"insecure_no_strict_host_key_checking": true,
+	})
+	if err := modeLocal.waitForTargets([]*sshTarget{
+		&sshTarget{alias: "booting-host", host: "127.0.0.1", port: port},
+	}, "", ansibleSSHSettings); err == nil {
+		t.Fatal("Expected the readiness check to time out")
+	}
+}
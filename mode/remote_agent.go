@@ -0,0 +1,96 @@
+package mode
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/hashicorp/terraform/communicator/remote"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// commandStarter starts remote commands, the communicator is one.
+type commandStarter interface {
+	Start(cmd *remote.Cmd) error
+}
+
+// agentForwardingStarter starts commands in sessions of its own SSH connection
+// with the local SSH agent forwarded. The communicator requests forwarding only
+// for a session of its own, commands started by the communicator never see the agent.
+type agentForwardingStarter struct {
+	conns []*ssh.Client
+	agent net.Conn
+}
+
+// newAgentForwardingStarter connects to the target, through the bastion if in use,
+// and serves the agent channels opened by the host with the local SSH agent.
+func newAgentForwardingStarter(connInfo *connectionInfo) (*agentForwardingStarter, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, fmt.Errorf("remote.forward_agent requires a local SSH agent, SSH_AUTH_SOCK is not set")
+	}
+	agentConn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed connecting to the local SSH agent at '%s': %v", socket, err)
+	}
+	conns, err := newTargetHostFromConnectionInfo(connInfo).connect()
+	if err != nil {
+		agentConn.Close()
+		return nil, err
+	}
+	starter := &agentForwardingStarter{
+		conns: conns,
+		agent: agentConn,
+	}
+	if err := agent.ForwardToAgent(starter.client(), agent.NewClient(agentConn)); err != nil {
+		starter.Close()
+		return nil, err
+	}
+	return starter, nil
+}
+
+func (s *agentForwardingStarter) client() *ssh.Client {
+	return s.conns[len(s.conns)-1]
+}
+
+// Start starts the command in a new session requesting agent forwarding,
+// the exit status is given to the command the way the communicator does.
+func (s *agentForwardingStarter) Start(cmd *remote.Cmd) error {
+	cmd.Init()
+	session, err := s.client().NewSession()
+	if err != nil {
+		return err
+	}
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		session.Close()
+		return fmt.Errorf("agent forwarding refused by the host: %v", err)
+	}
+	session.Stdin = cmd.Stdin
+	session.Stdout = cmd.Stdout
+	session.Stderr = cmd.Stderr
+	if err := session.Start(cmd.Command); err != nil {
+		session.Close()
+		return err
+	}
+	go func() {
+		defer session.Close()
+		err := session.Wait()
+		exitStatus := 0
+		if err != nil {
+			if exitErr, ok := err.(*ssh.ExitError); ok {
+				exitStatus = exitErr.ExitStatus()
+			}
+		}
+		cmd.SetExitStatus(exitStatus, err)
+	}()
+	return nil
+}
+
+func (s *agentForwardingStarter) Close() error {
+	// the target connection is closed before the bastion connection it goes through:
+	for i := len(s.conns) - 1; i >= 0; i-- {
+		s.conns[i].Close()
+	}
+	return s.agent.Close()
+}
@@ -0,0 +1,106 @@
+package mode
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func getTestAgentRemoteMode(t *testing.T, output terraform.UIOutput, useSudo bool) *RemoteMode {
+	return &RemoteMode{
+		o: output,
+		remoteSettings: test.GetNewRemoteSettingsWithDefaults(t, map[string]interface{}{
+			"use_sudo":      useSudo,
+			"forward_agent": true,
+		}),
+	}
+}
+
+func TestPlayCommandPreservesAgentSocket(t *testing.T) {
+	v := getTestAgentRemoteMode(t, nil, true)
+	expected := `cd "/srv/project" && sudo env SSH_AUTH_SOCK="$SSH_AUTH_SOCK" ansible-galaxy install -r requirements.yml`
+	if command := v.playCommand("/srv/project", "ansible-galaxy install -r requirements.yml", ""); command != expected {
+		t.Fatalf("Expected:\n%s\nbut got:\n%s", expected, command)
+	}
+}
+
+func TestAgentForwardingRequiresLocalAgent(t *testing.T) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	os.Unsetenv("SSH_AUTH_SOCK")
+	defer os.Setenv("SSH_AUTH_SOCK", socket)
+
+	_, err := newAgentForwardingStarter(&connectionInfo{})
+	if err == nil || !strings.Contains(err.Error(), "SSH_AUTH_SOCK is not set") {
+		t.Fatalf("Expected an error without a local agent but got: %v", err)
+	}
+}
+
+func TestAgentForwardingStarter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	listener, err := net.Listen("unix", filepath.Join(dir, "agent.sock"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		keyring := agent.NewKeyring()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", listener.Addr().String())
+	defer os.Setenv("SSH_AUTH_SOCK", socket)
+
+	// the server logs once the session is closed, the test must not complete before:
+	sessionClosed := make(chan struct{}, 1)
+	output := &terraform.MockUIOutput{
+		OutputFn: func(message string) {
+			if strings.HasSuffix(message, "sftp client exited session.") {
+				select {
+				case sessionClosed <- struct{}{}:
+				default:
+				}
+			}
+		},
+	}
+	instanceState := test.GetNewSSHInstanceState(t, "integration-test")
+	sshServer := test.GetConfiguredAndRunningSSHServer(t, "agent-forwarding", true, instanceState, output)
+	defer sshServer.Stop()
+
+	connInfo, err := parseConnectionInfo(instanceState)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	v := getTestAgentRemoteMode(t, new(terraform.MockUIOutput), false)
+	v.agentForwarding, err = newAgentForwardingStarter(connInfo)
+	if err != nil {
+		t.Fatalf("Expected agent forwarding but got: %v", err)
+	}
+	defer v.agentForwarding.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- v.runPlayCommand("", "echo forwarded", "")
+	}()
+	test.CommandTest(t, sshServer, `env SSH_AUTH_SOCK="$SSH_AUTH_SOCK" echo forwarded`)
+	if err := <-errCh; err != nil {
+		t.Fatalf("Expected the command to complete but got: %v", err)
+	}
+	<-sessionClosed
+}
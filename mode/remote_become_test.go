@@ -0,0 +1,98 @@
+package mode
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+	"github.com/radekg/terraform-provisioner-ansible/v2/types"
+)
+
+func getTestBecomeRemoteSettings(t *testing.T, becomeCommand string, becomeUser string, becomePassword string) *types.RemoteSettings {
+	return test.GetNewRemoteSettingsWithDefaults(t, map[string]interface{}{
+		"become_command":  becomeCommand,
+		"become_user":     becomeUser,
+		"become_password": becomePassword,
+	})
+}
+
+func TestBecomeCommand(t *testing.T) {
+	command := "ANSIBLE_FORCE_COLOR=true ansible-playbook 'site.yml'"
+	for _, tc := range []struct {
+		becomeCommand  string
+		becomeUser     string
+		becomePassword string
+		expected       string
+		preflight      string
+	}{
+		{"sudo", "", "", "sudo ANSIBLE_FORCE_COLOR=true ansible-playbook 'site.yml'", "sudo -n true"},
+		{"sudo", "ansible", "", "sudo -u 'ansible' ANSIBLE_FORCE_COLOR=true ansible-playbook 'site.yml'", "sudo -n -u 'ansible' true"},
+		{"sudo", "ansible", "secret", `sudo -S -k -p '' -u 'ansible' /bin/sh -c 'exec < /dev/null; ANSIBLE_FORCE_COLOR=true ansible-playbook '\''site.yml'\'''`, "sudo -S -k -p '' -u 'ansible' true"},
+		{"sudo", "it's; id", "", `sudo -u 'it'\''s; id' ANSIBLE_FORCE_COLOR=true ansible-playbook 'site.yml'`, `sudo -n -u 'it'\''s; id' true`},
+		{"doas", "", "", "doas env ANSIBLE_FORCE_COLOR=true ansible-playbook 'site.yml'", "doas -n true"},
+		{"pbrun", "ansible", "", "pbrun -u 'ansible' env ANSIBLE_FORCE_COLOR=true ansible-playbook 'site.yml'", ""},
+		{"su", "", "", `su 'root' -c 'ANSIBLE_FORCE_COLOR=true ansible-playbook '\''site.yml'\'''`, ""},
+	} {
+		remoteSettings := getTestBecomeRemoteSettings(t, tc.becomeCommand, tc.becomeUser, tc.becomePassword)
+		v := &RemoteMode{remoteSettings: remoteSettings}
+		if becomeCommand := v.becomeCommand(command); becomeCommand != tc.expected {
+			t.Fatalf("Expected:\n%s\nbut got:\n%s", tc.expected, becomeCommand)
+		}
+		if preflight := preflightBecomeCommand(remoteSettings); preflight != tc.preflight {
+			t.Fatalf("Expected the preflight command '%s' but got '%s'", tc.preflight, preflight)
+		}
+		stdin := v.becomeStdin()
+		if tc.becomePassword == "" {
+			if stdin != nil {
+				t.Fatalf("Did not expect an input for %s without a password", tc.becomeCommand)
+			}
+			continue
+		}
+		input, err := ioutil.ReadAll(stdin)
+		if err != nil || string(input) != tc.becomePassword+"\n" {
+			t.Fatalf("Expected the password as the input but got: %q, %v", string(input), err)
+		}
+	}
+}
+
+func TestBecomePasswordRequiresSudo(t *testing.T) {
+	instanceState := test.GetNewSSHInstanceState(t, "integration-test")
+	if _, err := NewRemoteMode(nil, instanceState, getTestBecomeRemoteSettings(t, "sudo", "", "secret")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err := NewRemoteMode(nil, instanceState, getTestBecomeRemoteSettings(t, "doas", "", "secret"))
+	if err == nil || !strings.Contains(err.Error(), "remote.become_password requires become_command 'sudo'") {
+		t.Fatalf("Expected the password to be rejected for doas but got: %v", err)
+	}
+}
+
+func TestBecomeUserReadsBootstrapDirectory(t *testing.T) {
+	instanceState := test.GetNewSSHInstanceState(t, "integration-test")
+	for _, user := range []string{"", "root", "integration-test"} {
+		if _, err := NewRemoteMode(nil, instanceState, getTestBecomeRemoteSettings(t, "sudo", user, "")); err != nil {
+			t.Fatalf("Expected become_user '%s' to be accepted but got: %v", user, err)
+		}
+	}
+	// a non-root user other than the connection user can not read the private bootstrap directory:
+	_, err := NewRemoteMode(nil, instanceState, getTestBecomeRemoteSettings(t, "sudo", "ansible", ""))
+	if err == nil || !strings.Contains(err.Error(), "remote.become_user 'ansible' can not read the private bootstrap directory") {
+		t.Fatalf("Expected the non-root become user to be rejected but got: %v", err)
+	}
+}
+
+func TestBecomePasswordNotGivenToCommand(t *testing.T) {
+	v := &RemoteMode{remoteSettings: getTestBecomeRemoteSettings(t, "sudo", "", "secret")}
+	becomeCommand := v.becomeCommand("cat")
+	if !strings.HasPrefix(becomeCommand, "sudo -S -k -p '' ") {
+		t.Fatalf("Unexpected become command: %s", becomeCommand)
+	}
+	// sudo does not prompt, the password remains on the input of the command:
+	command := exec.Command("/bin/sh", "-c", strings.TrimPrefix(becomeCommand, "sudo -S -k -p '' "))
+	command.Stdin = v.becomeStdin()
+	out, err := command.CombinedOutput()
+	if err != nil || len(out) > 0 {
+		t.Fatalf("Expected the command not to read the password but got: %q, %v", string(out), err)
+	}
+}
@@ -0,0 +1,157 @@
+package mode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+	"github.com/radekg/terraform-provisioner-ansible/v2/types"
+)
+
+func TestStableBootstrapDirectoryCommand(t *testing.T) {
+	base, err := ioutil.TempDir("", "bootstrap-directory")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	runCommand := func(dir string) error {
+		// the command is executed by the remote shell:
+		return exec.Command("/bin/sh", "-c", stableBootstrapDirectoryCommand(dir)).Run()
+	}
+
+	dir := filepath.Join(base, "tf-ansible-bootstrap")
+	if err := runCommand(dir); err != nil {
+		t.Fatalf("Expected the directory to be created but got: %v", err)
+	}
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := runCommand(dir); err != nil {
+		t.Fatalf("Expected the directory to be reused but got: %v", err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("Expected a private directory but got: %v, %v", info.Mode(), err)
+	}
+
+	// a symbolic link planted by someone else is never used:
+	link := filepath.Join(base, "tf-ansible-bootstrap-link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := runCommand(link); err == nil || !strings.Contains(err.Error(), "exit status 51") {
+		t.Fatalf("Expected a symbolic link to be rejected but got: %v", err)
+	}
+
+	file := filepath.Join(base, "tf-ansible-bootstrap-file")
+	if err := ioutil.WriteFile(file, []byte{}, 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := runCommand(file); err == nil {
+		t.Fatal("Expected a file to be rejected")
+	}
+}
+
+func TestIntegrationRemoteModeUploadCache(t *testing.T) {
+	bootstrapDirectory := test.CreateTempAnsibleBootstrapDir(t)
+	defer os.RemoveAll(bootstrapDirectory)
+	// an earlier run left its uploads in the cache:
+	stableDirectory := filepath.Join(bootstrapDirectory, "tf-ansible-bootstrap")
+	if err := os.Mkdir(stableDirectory, 0700); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(stableDirectory, "cached.sha256"), []byte{}, 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	output := new(terraform.MockUIOutput)
+	instanceState := test.GetNewSSHInstanceState(t, "integration-test")
+	sshServer := test.GetConfiguredAndRunningSSHServer(t, "upload-cache", false, instanceState, output)
+	defer sshServer.Stop()
+
+	tempAnsibleDataDir := test.CreateTempAnsibleDataDirectory(t)
+	defer os.RemoveAll(tempAnsibleDataDir)
+	playbookFilePath := test.WriteTempPlaybook(t, tempAnsibleDataDir)
+
+	remoteSettings := test.GetNewRemoteSettingsWithDefaults(t, map[string]interface{}{
+		"skip_install":               true,
+		"remote_installer_directory": bootstrapDirectory,
+		"bootstrap_directory":        bootstrapDirectory,
+		"upload_cache":               true,
+	})
+	modeRemote, err := NewRemoteMode(output, instanceState, remoteSettings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	defaultSettings := test.GetDefaultSettingsForUser(t, test.GetCurrentUser(t))
+	playPlaybookRawConfigs := test.GetPlayPlaybookSchema(t, playbookFilePath)
+	play := types.NewPlayFromMapInterface(map[string]interface{}{
+		"enabled":             true,
+		"become":              false,
+		"become_method":       defaultSettings.BecomeMethod(),
+		"become_user":         defaultSettings.BecomeUser(),
+		"diff":                false,
+		"check":               false,
+		"forks":               5,
+		"inventory_file":      "",
+		"limit":               "",
+		"vault_id":            []interface{}{},
+		"vault_password_file": "",
+		"verbose":             false,
+		"extra_vars":          map[string]interface{}{},
+		"module":              playPlaybookRawConfigs.Get("module").(*schema.Set),
+		"playbook":            playPlaybookRawConfigs.Get("playbook").(*schema.Set),
+	}, defaultSettings)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if runErr := modeRemote.Run([]*types.Play{play}); runErr != nil {
+			t.Errorf("Unexpected error: %v", runErr)
+		}
+	}()
+
+	test.CommandTest(t, sshServer, fmt.Sprintf("/bin/sh -c 'mkdir -p -m 0700 \"%s\"", stableDirectory))
+	test.CommandTest(t, sshServer, "/bin/sh -c 'probe=")
+	test.CommandTest(t, sshServer, "df -Pk")
+	test.CommandTest(t, sshServer, "sudo -n true")
+	test.CommandTest(t, sshServer, "command -v python3")
+	test.CommandTest(t, sshServer, "command -v ansible-playbook")
+
+	// the playbook directory is uploaded to the cache:
+	test.CommandTest(t, sshServer, fmt.Sprintf("/bin/sh -c 'if [ -f \"%s/", stableDirectory))
+	test.CommandTest(t, sshServer, fmt.Sprintf("cat \"%s/", stableDirectory))
+	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", stableDirectory))
+	test.CommandTest(t, sshServer, "/bin/sh -c 'echo")
+	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", stableDirectory))
+	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", stableDirectory))
+	// the inventory is written to the directory of the run, not to the cache:
+	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s-", stableDirectory))
+
+	test.CommandTest(t, sshServer, "sudo ANSIBLE_FORCE_COLOR=true ansible-playbook")
+
+	wg.Wait()
+
+	// the directory of the run is removed, the cache is kept for the next run:
+	entries, err := ioutil.ReadDir(bootstrapDirectory)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "tf-ansible-bootstrap" {
+		t.Fatalf("Expected only the cache to remain but found: %v", entries)
+	}
+	if _, err := os.Stat(filepath.Join(stableDirectory, "cached.sha256")); err != nil {
+		t.Fatalf("Expected the cached upload to remain but got: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package mode
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/radekg/terraform-provisioner-ansible/v2/shellescape"
+)
+
+const (
+	// exit codes of the detached play state command, a running play exits with 0:
+	detachedFinishedExitCode = 80
+	detachedGoneExitCode     = 81
+	// reconnecting waits at most this long between the attempts:
+	detachedMaxRetryDelay = 30 * time.Second
+	// the start command waits at most this number of seconds for the play to write its process ID:
+	detachedStartSeconds = 10
+)
+
+// detachedPollInterval is the time between reading the output of a running detached play.
+var detachedPollInterval = 2 * time.Second
+
+// detachedPlay is a play running in the background on the host, its output, exit status and process ID
+// are written to files in the bootstrap directory.
+type detachedPlay struct {
+	outputPath string
+	statusPath string
+	pidPath    string
+}
+
+func newDetachedPlay(dir string, index int) *detachedPlay {
+	return &detachedPlay{
+		outputPath: filepath.Join(dir, fmt.Sprintf("detached-play-%d.out", index)),
+		statusPath: filepath.Join(dir, fmt.Sprintf("detached-play-%d.status", index)),
+		pidPath:    filepath.Join(dir, fmt.Sprintf("detached-play-%d.pid", index)),
+	}
+}
+
+// startCommand returns a command starting the play command in a new session, setsid where available,
+// immune to hangups. The process ID is written by the play itself, setsid may fork, the command returns
+// once the process ID is written and fails when the play does not start within detachedStartSeconds.
+// The exit status is written to a temporary file first, a status file is never read half written.
+// The command runs in a subshell, an exit in the command still writes the status.
+func (p *detachedPlay) startCommand(command string) string {
+	script := fmt.Sprintf("echo $$ > \"%s\"; ( %s ); echo $? > \"%s.tmp\" && mv \"%s.tmp\" \"%s\"",
+		p.pidPath, command, p.statusPath, p.statusPath, p.statusPath)
+	return fmt.Sprintf("rm -f \"%s\" \"%s\" \"%s\"; setsid=$(command -v setsid); $setsid nohup /bin/sh -c '%s' > \"%s\" 2>&1 < /dev/null & n=0; until [ -s \"%s\" ] || [ $n -ge %d ]; do sleep 1; n=$((n+1)); done; [ -s \"%s\" ]",
+		p.statusPath,
+		p.outputPath,
+		p.pidPath,
+		shellescape.NewSingleQuoteEscape(script).Safe(),
+		p.outputPath,
+		p.pidPath,
+		detachedStartSeconds,
+		p.pidPath)
+}
+
+// stateCommand returns a command exiting with 0 while the play runs, with detachedFinishedExitCode once
+// the exit status is written and with detachedGoneExitCode when the play is not running and there is no
+// exit status, the host rebooted or the play was killed.
+func (p *detachedPlay) stateCommand() string {
+	return fmt.Sprintf("/bin/sh -c '[ -f \"%s\" ] && exit %d; pid=$(cat \"%s\" 2>/dev/null); [ -n \"$pid\" ] && ps -p \"$pid\" >/dev/null 2>&1 && exit 0; [ -f \"%s\" ] && exit %d; exit %d'",
+		p.statusPath,
+		detachedFinishedExitCode,
+		p.pidPath,
+		p.statusPath,
+		detachedFinishedExitCode,
+		detachedGoneExitCode)
+}
+
+// outputCommand returns a command printing the output of the play from the given byte offset.
+func (p *detachedPlay) outputCommand(offset int) string {
+	return fmt.Sprintf("tail -c +%d \"%s\"", offset+1, p.outputPath)
+}
+
+func (p *detachedPlay) statusCommand() string {
+	return fmt.Sprintf("cat \"%s\"", p.statusPath)
+}
+
+// wait tails the output of the play until the exit status is written, every complete line is given to emit.
+// Every command runs with retry, a failed session does not end the wait, the host may be rebooting.
+func (p *detachedPlay) wait(output func(command string) (string, error), retry func(f func() error) error, emit func(line string)) (int, error) {
+	offset := 0
+	pending := ""
+	tail := func() error {
+		return retry(func() error {
+			data, err := output(p.outputCommand(offset))
+			if err := detachedSessionError(err); err != nil {
+				return err
+			}
+			offset = offset + len(data)
+			lines := strings.Split(pending+data, "\n")
+			// the last line is incomplete until a new line arrives:
+			pending = lines[len(lines)-1]
+			for _, line := range lines[:len(lines)-1] {
+				emit(strings.ToValidUTF8(line, ""))
+			}
+			return nil
+		})
+	}
+
+	for {
+		state := 0
+		err := retry(func() error {
+			_, err := output(p.stateCommand())
+			if err := detachedSessionError(err); err != nil {
+				return err
+			}
+			state, _ = remoteExitStatus(err)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		// the output is read after the state, the output of a finished play is complete:
+		if err := tail(); err != nil {
+			return 0, err
+		}
+		switch state {
+		case 0:
+			time.Sleep(detachedPollInterval)
+			continue
+		case detachedFinishedExitCode:
+		case detachedGoneExitCode:
+			return 0, fmt.Errorf("the detached play ended without an exit status, the host rebooted or the play was killed; its output is in '%s'", p.outputPath)
+		default:
+			return 0, fmt.Errorf("failed reading the state of the detached play, exit status %d", state)
+		}
+		if pending != "" {
+			emit(strings.ToValidUTF8(pending, ""))
+		}
+		var status string
+		err = retry(func() error {
+			status, err = output(p.statusCommand())
+			return detachedSessionError(err)
+		})
+		if err != nil {
+			return 0, err
+		}
+		exitStatus, err := strconv.Atoi(strings.TrimSpace(status))
+		if err != nil {
+			return 0, fmt.Errorf("invalid exit status of the detached play '%s'", strings.TrimSpace(status))
+		}
+		return exitStatus, nil
+	}
+}
+
+// detachedSessionError returns the error unless the command ran and exited with a non-zero status,
+// the exit status is the result of the command then. A session lost before the command exits
+// ends without a status.
+func detachedSessionError(err error) error {
+	if status, ok := remoteExitStatus(err); ok && status != 0 {
+		return nil
+	}
+	return err
+}
+
+// runDetachedPlayCommand starts the play in the background and follows its output over new sessions,
+// reconnecting with backoff for as long as the communicator timeout when the connection is lost.
+func (v *RemoteMode) runDetachedPlayCommand(index int, dir string, command string, logPath string) error {
+	play := newDetachedPlay(v.remoteSettings.BootstrapDirectory(), index)
+	startCommand := play.startCommand(v.playCommand(dir, command, logPath))
+	if err := v.runCommandNoSudo(startCommand); err != nil {
+		return err
+	}
+	v.o.Output(fmt.Sprintf("Play running detached, output in '%s'.", play.outputPath))
+
+	reconnected := false
+	retry := func(f func() error) error {
+		return v.retryFuncWithBackoff(v.comm.Timeout(), 3*time.Second, detachedMaxRetryDelay, func() error {
+			err := f()
+			if err != nil {
+				reconnected = true
+				v.o.Output(fmt.Sprintf("Lost the connection to the detached play, reconnecting: %v", err))
+			}
+			return err
+		})
+	}
+	exitStatus, err := play.wait(v.runCommandOutput, retry, v.o.Output)
+	if reconnected {
+		// the SFTP connection did not survive either:
+		v.fs.Close()
+		v.fs = v.openRemoteFS()
+	}
+	if err != nil {
+		return err
+	}
+	if exitStatus != 0 {
+		return &remoteCommandError{command: startCommand, exitStatus: exitStatus}
+	}
+	return nil
+}
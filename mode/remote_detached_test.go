@@ -0,0 +1,160 @@
+package mode
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+)
+
+func startTestDetachedPlay(t *testing.T, command string) (*detachedPlay, func()) {
+	dir, err := ioutil.TempDir("", "detached")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	play := newDetachedPlay(dir, 0)
+	if err := newLocalShellRemoteFS().run(play.startCommand(command)); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return play, func() { os.RemoveAll(dir) }
+}
+
+func noTestRetry(f func() error) error {
+	return f()
+}
+
+func TestDetachedPlay(t *testing.T) {
+	interval := detachedPollInterval
+	detachedPollInterval = 50 * time.Millisecond
+	defer func() { detachedPollInterval = interval }()
+
+	play, cleanup := startTestDetachedPlay(t, "echo first; sleep 1; printf 'second\\nno new line'; exit 3")
+	defer cleanup()
+
+	lines := make([]string, 0)
+	exitStatus, err := play.wait(newLocalShellRemoteFS().output, noTestRetry, func(line string) {
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exitStatus != 3 {
+		t.Fatalf("Expected the exit status of the play but got %d", exitStatus)
+	}
+	if strings.Join(lines, "|") != "first|second|no new line" {
+		t.Fatalf("Unexpected output: %v", lines)
+	}
+}
+
+func TestDetachedPlayReconnects(t *testing.T) {
+	interval := detachedPollInterval
+	detachedPollInterval = 50 * time.Millisecond
+	defer func() { detachedPollInterval = interval }()
+
+	play, cleanup := startTestDetachedPlay(t, "for i in 1 2 3 4 5; do echo line-$i; sleep 0.1; done")
+	defer cleanup()
+
+	// every other command fails like a lost session, the retry runs it again:
+	calls := 0
+	output := func(command string) (string, error) {
+		calls++
+		if calls%2 == 0 {
+			return "", &remoteCommandError{command: command, err: errors.New("session lost")}
+		}
+		return newLocalShellRemoteFS().output(command)
+	}
+	retries := 0
+	retry := func(f func() error) error {
+		for {
+			if err := f(); err == nil {
+				return nil
+			}
+			retries++
+		}
+	}
+	lines := make([]string, 0)
+	exitStatus, err := play.wait(output, retry, func(line string) {
+		lines = append(lines, line)
+	})
+	if err != nil || exitStatus != 0 {
+		t.Fatalf("Expected the play to succeed but got: %d, %v", exitStatus, err)
+	}
+	if retries == 0 {
+		t.Fatal("Expected the lost sessions to be retried")
+	}
+	if strings.Join(lines, "|") != "line-1|line-2|line-3|line-4|line-5" {
+		t.Fatalf("Expected every line once but got: %v", lines)
+	}
+}
+
+func TestDetachedPlayGone(t *testing.T) {
+	interval := detachedPollInterval
+	detachedPollInterval = 50 * time.Millisecond
+	defer func() { detachedPollInterval = interval }()
+
+	play, cleanup := startTestDetachedPlay(t, "true")
+	defer cleanup()
+	if _, err := play.wait(newLocalShellRemoteFS().output, noTestRetry, func(string) {}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the host rebooted, the play is not running and there is no exit status:
+	exited := exec.Command("/bin/sh", "-c", "exit 0")
+	if err := exited.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	os.Remove(play.statusPath)
+	if err := ioutil.WriteFile(play.pidPath, []byte(strconv.Itoa(exited.Process.Pid)), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err := play.wait(newLocalShellRemoteFS().output, noTestRetry, func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "ended without an exit status") {
+		t.Fatalf("Expected the play to be gone but got: %v", err)
+	}
+}
+
+func TestDetachedRequiresPlainSession(t *testing.T) {
+	instanceState := test.GetNewSSHInstanceState(t, "integration-test")
+	for _, tc := range []struct {
+		key   string
+		value interface{}
+	}{
+		{"become_password", "secret"},
+		{"forward_agent", true},
+	} {
+		_, err := NewRemoteMode(nil, instanceState, test.GetNewRemoteSettingsWithDefaults(t, map[string]interface{}{
+			"bootstrap_directory": filepath.Join(os.TempDir(), "detached"),
+			"detached":            true,
+			tc.key:                tc.value,
+		}))
+		if err == nil || !strings.Contains(err.Error(), "remote.detached can not be used with remote."+tc.key) {
+			t.Fatalf("Expected detached to be rejected with %s but got: %v", tc.key, err)
+		}
+	}
+}
+
+func TestDetachedPlayWritesItsProcessID(t *testing.T) {
+	play, cleanup := startTestDetachedPlay(t, "sleep 5")
+	defer cleanup()
+
+	// the process ID is there once the start command returns, written once by the play:
+	pid, err := ioutil.ReadFile(play.pidPath)
+	if err != nil {
+		t.Fatalf("Expected the process ID to be written but got: %v", err)
+	}
+	if _, err := strconv.Atoi(strings.TrimSpace(string(pid))); err != nil {
+		t.Fatalf("Expected a single process ID but got: %q", string(pid))
+	}
+	exitStatus, err := newLocalShellRemoteFS().output(play.stateCommand())
+	if err != nil {
+		t.Fatalf("Expected the play to be running but got: %s, %v", exitStatus, err)
+	}
+}
@@ -0,0 +1,39 @@
+package mode
+
+import (
+	"fmt"
+	"path"
+)
+
+// fetchArtifacts downloads the remote.fetch paths once the plays succeed, before the cleanup.
+// With use_sudo, the plays usually leave files only root can read, each source is copied
+// to the bootstrap directory with the become command and handed to the connection user first.
+func (v *RemoteMode) fetchArtifacts() error {
+	for i, fetch := range v.remoteSettings.Fetch() {
+		v.o.Output(fmt.Sprintf("fetching '%s' to '%s'...", fetch.Source(), fetch.Destination()))
+		source := fetch.Source()
+		if v.remoteSettings.UseSudo() {
+			source = path.Join(v.remoteSettings.BootstrapDirectory(), fmt.Sprintf(".fetch-%d", i))
+			if err := v.runCommandSudo(fetchStageCommand(fetch.Source(), source, v.connInfo.User)); err != nil {
+				return fmt.Errorf("failed staging '%s' for download: %v", fetch.Source(), err)
+			}
+		}
+		err := v.fs.Download(source, fetch.Destination(), fetch.Mode())
+		if source != fetch.Source() {
+			if removeErr := v.fs.Remove(source); removeErr != nil {
+				v.o.Output(fmt.Sprintf("failed removing the staged copy '%s': %v", source, removeErr))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed fetching '%s': %v", fetch.Source(), err)
+		}
+	}
+	return nil
+}
+
+// fetchStageCommand returns a command copying the source, a symbolic link is followed,
+// and giving the copy to the user. A copy left behind by an earlier run is removed first,
+// cp would otherwise copy a directory into it.
+func fetchStageCommand(source string, staged string, user string) string {
+	return fmt.Sprintf("/bin/sh -c 'rm -rf \"%s\" && cp -RH \"%s\" \"%s\" && chown -R \"%s\" \"%s\"'", staged, source, staged, user, staged)
+}
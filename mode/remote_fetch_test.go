@@ -0,0 +1,133 @@
+package mode
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+)
+
+func writeTestFetchSource(t *testing.T, dir string) {
+	if err := os.MkdirAll(filepath.Join(dir, "pki", "private"), 0755); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "pki", "ca.crt"), []byte("certificate"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "pki", "private", "ca.key"), []byte("key"), 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := os.Symlink("ca.crt", filepath.Join(dir, "pki", "current.crt")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "pki", "ca.crt"), filepath.Join(dir, "admin.conf")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func expectTestFetchedFile(t *testing.T, p string, contents string, perm os.FileMode) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil || string(data) != contents {
+		t.Fatalf("Expected '%s' with '%s' but got: '%s', %v", p, contents, string(data), err)
+	}
+	info, err := os.Stat(p)
+	if err != nil || info.Mode().Perm() != perm {
+		t.Fatalf("Expected '%s' with permissions %v but got: %v, %v", p, perm, info.Mode().Perm(), err)
+	}
+}
+
+func TestSFTPRemoteFSDownload(t *testing.T) {
+	output := new(terraform.MockUIOutput)
+	instanceState := test.GetNewSSHInstanceState(t, "integration-test")
+	sshServer := test.GetConfiguredAndRunningSSHServer(t, "remote-fetch", false, instanceState, output)
+	defer sshServer.Stop()
+
+	connInfo, err := parseConnectionInfo(instanceState)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fs, err := newSFTPRemoteFS(connInfo, newLocalShellRemoteFS())
+	if err != nil {
+		t.Fatalf("Expected an SFTP session but got: %v", err)
+	}
+	defer fs.Close()
+
+	remoteDir, err := ioutil.TempDir("", "remote-fetch")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(remoteDir)
+	writeTestFetchSource(t, remoteDir)
+	localDir, err := ioutil.TempDir("", "local-fetch")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(localDir)
+
+	// a directory keeps the remote permissions, links inside are not followed:
+	if err := fs.Download(filepath.Join(remoteDir, "pki"), filepath.Join(localDir, "pki"), 0); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectTestFetchedFile(t, filepath.Join(localDir, "pki", "ca.crt"), "certificate", 0644)
+	expectTestFetchedFile(t, filepath.Join(localDir, "pki", "private", "ca.key"), "key", 0600)
+	if target, err := os.Readlink(filepath.Join(localDir, "pki", "current.crt")); err != nil || target != "ca.crt" {
+		t.Fatalf("Expected a symbolic link but got: '%s', %v", target, err)
+	}
+
+	// the source link is followed, an existing file is replaced with the given permissions:
+	destination := filepath.Join(localDir, "kube", "config")
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(destination, []byte("previous contents"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := fs.Download(filepath.Join(remoteDir, "admin.conf"), destination, 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectTestFetchedFile(t, destination, "certificate", 0600)
+
+	if err := fs.Download(filepath.Join(remoteDir, "missing"), filepath.Join(localDir, "missing"), 0); err == nil {
+		t.Fatal("Expected an error for a missing path")
+	}
+	if err := newLocalShellRemoteFS().Download(filepath.Join(remoteDir, "pki"), localDir, 0); err == nil {
+		t.Fatal("Expected downloads to require SFTP")
+	}
+}
+
+func TestFetchStageCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote-fetch")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFetchSource(t, dir)
+
+	user := test.GetCurrentUser(t)
+	staged := filepath.Join(dir, ".fetch-0")
+	if err := newLocalShellRemoteFS().run(fetchStageCommand(filepath.Join(dir, "admin.conf"), staged, user.Username)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info, err := os.Lstat(staged); err != nil || info.Mode()&os.ModeSymlink != 0 {
+		t.Fatalf("Expected a copy of the link target but got: %v, %v", info, err)
+	}
+	expectTestFetchedFile(t, staged, "certificate", 0644)
+
+	// a stale copy of a directory is replaced, not copied into:
+	staged = filepath.Join(dir, ".fetch-1")
+	if err := os.MkdirAll(filepath.Join(staged, "stale"), 0755); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := newLocalShellRemoteFS().run(fetchStageCommand(filepath.Join(dir, "pki"), staged, user.Username)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectTestFetchedFile(t, filepath.Join(staged, "ca.crt"), "certificate", 0644)
+	for _, p := range []string{"stale", "pki"} {
+		if _, err := os.Stat(filepath.Join(staged, p)); err == nil {
+			t.Fatalf("Did not expect '%s' in the staged copy", p)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package mode
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// exit codes of the shell stat command, a missing path exits with 0:
+	remoteFSFileExitCode      = 60
+	remoteFSDirectoryExitCode = 61
+	remoteFSSymlinkExitCode   = 62
+)
+
+// remoteFS is the filesystem of the provisioned host.
+type remoteFS interface {
+	// Stat describes the path, symbolic links are not followed.
+	Stat(path string) (remoteFileInfo, error)
+	// MkdirAll creates the directory and its missing parents, like mkdir -p.
+	MkdirAll(path string) error
+	// Mkdir creates the directory with the given permissions, the directory must not exist.
+	Mkdir(path string, perm os.FileMode) error
+	// Remove removes the path, directories with their contents, like rm -rf. A missing path is not an error.
+	Remove(path string) error
+	// Chmod changes the permissions of the path.
+	Chmod(path string, perm os.FileMode) error
+	// SHA256 returns the hex encoded sha256 digest of the file.
+	SHA256(path string) (string, error)
+	// Download copies the remote file to the local path or the contents of the remote directory
+	// to the local directory. Files get the given permissions, the remote permissions when 0.
+	Download(remotePath string, localPath string, perm os.FileMode) error
+	Close() error
+}
+
+// remoteFileInfo describes a remote path.
+type remoteFileInfo struct {
+	exists  bool
+	dir     bool
+	symlink bool
+}
+
+// sftpRemoteFS is the remote filesystem over an SFTP session of its own SSH connection.
+type sftpRemoteFS struct {
+	client *sftp.Client
+	conns  []*ssh.Client
+	// SFTP has no checksum request, reading the file back would transfer it again:
+	hasher *shellRemoteFS
+}
+
+// newSFTPRemoteFS connects to the target, through the bastion if in use, and starts an SFTP session.
+// An error is returned when the server does not offer the sftp subsystem.
+func newSFTPRemoteFS(connInfo *connectionInfo, hasher *shellRemoteFS) (*sftpRemoteFS, error) {
+	conns, err := newTargetHostFromConnectionInfo(connInfo).connect()
+	if err != nil {
+		return nil, err
+	}
+	fs := &sftpRemoteFS{
+		conns:  conns,
+		hasher: hasher,
+	}
+	fs.client, err = sftp.NewClient(conns[len(conns)-1])
+	if err != nil {
+		fs.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *sftpRemoteFS) Stat(p string) (remoteFileInfo, error) {
+	info, err := fs.client.Lstat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return remoteFileInfo{}, nil
+		}
+		return remoteFileInfo{}, err
+	}
+	return remoteFileInfo{
+		exists:  true,
+		dir:     info.IsDir(),
+		symlink: info.Mode()&os.ModeSymlink != 0,
+	}, nil
+}
+
+func (fs *sftpRemoteFS) MkdirAll(p string) error {
+	return fs.client.MkdirAll(p)
+}
+
+// Mkdir creates the directory with the default permissions of the server and restricts them right after,
+// the SFTP client does not pass the permissions with mkdir. Anything placed in the directory in between
+// would remain accessible, in that case the directory is rejected.
+func (fs *sftpRemoteFS) Mkdir(p string, perm os.FileMode) error {
+	if err := fs.client.Mkdir(p); err != nil {
+		return fmt.Errorf("failed creating '%s': %v", p, err)
+	}
+	if err := fs.client.Chmod(p, perm); err != nil {
+		return err
+	}
+	entries, err := fs.client.ReadDir(p)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("directory '%s' is not empty right after creating it", p)
+	}
+	return nil
+}
+
+func (fs *sftpRemoteFS) Remove(p string) error {
+	info, err := fs.Stat(p)
+	if err != nil || !info.exists {
+		return err
+	}
+	if info.dir {
+		entries, err := fs.client.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := fs.Remove(path.Join(p, entry.Name())); err != nil {
+				return err
+			}
+		}
+		return fs.client.RemoveDirectory(p)
+	}
+	return fs.client.Remove(p)
+}
+
+func (fs *sftpRemoteFS) Chmod(p string, perm os.FileMode) error {
+	return fs.client.Chmod(p, perm)
+}
+
+func (fs *sftpRemoteFS) SHA256(p string) (string, error) {
+	return fs.hasher.SHA256(p)
+}
+
+func (fs *sftpRemoteFS) Download(remotePath string, localPath string, perm os.FileMode) error {
+	// the source itself may be a symbolic link, like a kubeconfig linked from /etc:
+	info, err := fs.client.Stat(remotePath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fs.downloadFile(remotePath, localPath, info, perm)
+	}
+	// the local directory must remain writable while the contents arrive:
+	if err := os.MkdirAll(localPath, info.Mode().Perm()|0700); err != nil {
+		return err
+	}
+	entries, err := fs.client.ReadDir(remotePath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		remoteEntry := path.Join(remotePath, entry.Name())
+		localEntry := filepath.Join(localPath, entry.Name())
+		if entry.Mode()&os.ModeSymlink != 0 {
+			// links within the directory are not followed, they may point anywhere on the host:
+			target, err := fs.client.ReadLink(remoteEntry)
+			if err != nil {
+				return err
+			}
+			os.Remove(localEntry)
+			if err := os.Symlink(target, localEntry); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fs.Download(remoteEntry, localEntry, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *sftpRemoteFS) downloadFile(remotePath string, localPath string, info os.FileInfo, perm os.FileMode) error {
+	if perm == 0 {
+		perm = info.Mode().Perm()
+	}
+	source, err := fs.client.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	destination, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+	// an existing file keeps its permissions, restrict them before the contents arrive:
+	if err := destination.Chmod(perm); err != nil {
+		return err
+	}
+	if _, err := io.Copy(destination, source); err != nil {
+		return err
+	}
+	return destination.Close()
+}
+
+func (fs *sftpRemoteFS) Close() error {
+	if fs.client != nil {
+		fs.client.Close()
+	}
+	// the target connection is closed before the bastion connection it goes through:
+	for i := len(fs.conns) - 1; i >= 0; i-- {
+		fs.conns[i].Close()
+	}
+	return nil
+}
+
+// shellRemoteFS is the remote filesystem over shell commands, results are given with exit codes.
+type shellRemoteFS struct {
+	run    func(command string) error
+	output func(command string) (string, error)
+}
+
+func (fs *shellRemoteFS) Stat(p string) (remoteFileInfo, error) {
+	err := fs.run(remoteFSStatCommand(p))
+	if err == nil {
+		return remoteFileInfo{}, nil
+	}
+	status, ok := remoteExitStatus(err)
+	if !ok {
+		return remoteFileInfo{}, err
+	}
+	switch status {
+	case remoteFSFileExitCode:
+		return remoteFileInfo{exists: true}, nil
+	case remoteFSDirectoryExitCode:
+		return remoteFileInfo{exists: true, dir: true}, nil
+	case remoteFSSymlinkExitCode:
+		return remoteFileInfo{exists: true, symlink: true}, nil
+	}
+	return remoteFileInfo{}, err
+}
+
+func (fs *shellRemoteFS) MkdirAll(p string) error {
+	return fs.run(fmt.Sprintf("mkdir -p \"%s\"", p))
+}
+
+func (fs *shellRemoteFS) Mkdir(p string, perm os.FileMode) error {
+	return fs.run(fmt.Sprintf("mkdir -m %04o \"%s\"", perm.Perm(), p))
+}
+
+func (fs *shellRemoteFS) Remove(p string) error {
+	return fs.run(fmt.Sprintf("rm -rf \"%s\"", p))
+}
+
+func (fs *shellRemoteFS) Chmod(p string, perm os.FileMode) error {
+	return fs.run(fmt.Sprintf("chmod %04o \"%s\"", perm.Perm(), p))
+}
+
+func (fs *shellRemoteFS) SHA256(p string) (string, error) {
+	output, err := fs.output(fmt.Sprintf("sha256sum \"%s\"", p))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(output)
+	if len(fields) == 0 || len(fields[0]) != 64 {
+		return "", fmt.Errorf("unexpected sha256sum output for '%s': '%s'", p, strings.TrimSpace(output))
+	}
+	return fields[0], nil
+}
+
+// Download is not supported over shell commands, command output is text.
+func (fs *shellRemoteFS) Download(remotePath string, localPath string, perm os.FileMode) error {
+	return fmt.Errorf("downloading '%s' requires SFTP, the host does not offer the sftp subsystem", remotePath)
+}
+
+func (fs *shellRemoteFS) Close() error {
+	return nil
+}
+
+// remoteFSStatCommand returns a command describing the path with its exit code,
+// symbolic links are not followed.
+func remoteFSStatCommand(p string) string {
+	return fmt.Sprintf("/bin/sh -c 'if [ -L \"%s\" ]; then exit %d; elif [ -d \"%s\" ]; then exit %d; elif [ -e \"%s\" ]; then exit %d; fi'",
+		p, remoteFSSymlinkExitCode,
+		p, remoteFSDirectoryExitCode,
+		p, remoteFSFileExitCode)
+}
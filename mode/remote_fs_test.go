@@ -0,0 +1,141 @@
+package mode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+)
+
+// newLocalShellRemoteFS returns a shell filesystem executing the commands with the local shell.
+func newLocalShellRemoteFS() *shellRemoteFS {
+	commandError := func(command string, err error) error {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return &remoteCommandError{command: command, exitStatus: exitErr.ExitCode(), err: err}
+		}
+		return err
+	}
+	return &shellRemoteFS{
+		run: func(command string) error {
+			return commandError(command, exec.Command("/bin/sh", "-c", command).Run())
+		},
+		output: func(command string) (string, error) {
+			output, err := exec.Command("/bin/sh", "-c", command).Output()
+			return string(output), commandError(command, err)
+		},
+	}
+}
+
+func testRemoteFS(t *testing.T, fs remoteFS) {
+	base, err := ioutil.TempDir("", "remote-fs")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	dir := filepath.Join(base, "a", "b")
+	if err := fs.MkdirAll(dir); err != nil {
+		t.Fatalf("Expected the directories to be created but got: %v", err)
+	}
+	if err := fs.MkdirAll(dir); err != nil {
+		t.Fatalf("Expected an existing directory to be accepted but got: %v", err)
+	}
+	if info, err := fs.Stat(dir); err != nil || !info.exists || !info.dir || info.symlink {
+		t.Fatalf("Expected a directory but got: %+v, %v", info, err)
+	}
+
+	private := filepath.Join(base, "private")
+	if err := fs.Mkdir(private, 0700); err != nil {
+		t.Fatalf("Expected the directory to be created but got: %v", err)
+	}
+	if info, err := os.Stat(private); err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("Expected a private directory but got: %v, %v", info.Mode(), err)
+	}
+	if err := fs.Mkdir(private, 0700); err == nil {
+		t.Fatal("Expected an existing directory to be rejected")
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("contents"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info, err := fs.Stat(file); err != nil || !info.exists || info.dir {
+		t.Fatalf("Expected a file but got: %+v, %v", info, err)
+	}
+	if err := fs.Chmod(file, 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected changed permissions but got: %v, %v", info.Mode(), err)
+	}
+	digest := sha256.Sum256([]byte("contents"))
+	if remoteDigest, err := fs.SHA256(file); err != nil || remoteDigest != hex.EncodeToString(digest[:]) {
+		t.Fatalf("Unexpected sha256: '%s', %v", remoteDigest, err)
+	}
+	if _, err := fs.SHA256(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("Expected an error for a missing file")
+	}
+
+	link := filepath.Join(base, "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info, err := fs.Stat(link); err != nil || !info.exists || !info.symlink || info.dir {
+		t.Fatalf("Expected a symbolic link but got: %+v, %v", info, err)
+	}
+
+	if err := fs.Remove(link); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatal("Expected the target of a removed symbolic link to remain")
+	}
+	if err := fs.Remove(filepath.Join(base, "a")); err != nil {
+		t.Fatalf("Expected the directory to be removed but got: %v", err)
+	}
+	if info, err := fs.Stat(filepath.Join(base, "a")); err != nil || info.exists {
+		t.Fatalf("Expected a missing path but got: %+v, %v", info, err)
+	}
+	if err := fs.Remove(filepath.Join(base, "a")); err != nil {
+		t.Fatalf("Expected a missing path to be accepted but got: %v", err)
+	}
+}
+
+func TestShellRemoteFS(t *testing.T) {
+	testRemoteFS(t, newLocalShellRemoteFS())
+}
+
+func TestSFTPRemoteFS(t *testing.T) {
+	output := new(terraform.MockUIOutput)
+	instanceState := test.GetNewSSHInstanceState(t, "integration-test")
+	sshServer := test.GetConfiguredAndRunningSSHServer(t, "remote-fs", false, instanceState, output)
+	defer sshServer.Stop()
+
+	connInfo, err := parseConnectionInfo(instanceState)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fs, err := newSFTPRemoteFS(connInfo, newLocalShellRemoteFS())
+	if err != nil {
+		t.Fatalf("Expected an SFTP session but got: %v", err)
+	}
+	defer fs.Close()
+	testRemoteFS(t, fs)
+}
+
+func TestRemoteExitStatus(t *testing.T) {
+	err := fmt.Errorf("failed: %w", &remoteCommandError{command: "exit 50", exitStatus: 50})
+	if status, ok := remoteExitStatus(err); !ok || status != 50 {
+		t.Fatalf("Expected the exit status of a wrapped error but got: %d, %v", status, ok)
+	}
+	if _, ok := remoteExitStatus(fmt.Errorf("Command 'exit 50' exited with non-zero exit status: 50, reason <nil>")); ok {
+		t.Fatal("Did not expect an exit status for an error message")
+	}
+}
@@ -0,0 +1,192 @@
+package mode
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+)
+
+// writeInstallerStubs writes package manager and Ansible stubs reporting the given package version.
+func writeInstallerStubs(t *testing.T, installedVersion string) string {
+	dir, err := ioutil.TempDir("", "installer-stubs")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stubs := map[string]string{
+		"apt-get":          "#!/bin/sh\nexit 0\n",
+		"dpkg-query":       "#!/bin/sh\nprintf '%s' '" + installedVersion + "'\n",
+		"ansible-playbook": "#!/bin/sh\nexit 0\n",
+	}
+	for name, contents := range stubs {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0755); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	return dir
+}
+
+func runTestInstaller(t *testing.T, installer *ansibleInstaller, stubsDir string) (string, error) {
+	var buf bytes.Buffer
+	if err := template.Must(template.New("installer").Parse(installerProgramTemplate)).Execute(&buf, installer); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cmd := exec.Command("/bin/sh", "-c", buf.String())
+	cmd.Env = append(os.Environ(), "PATH="+stubsDir+":"+os.Getenv("PATH"))
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+func TestInstallerPackageMethodVerifiesVersion(t *testing.T) {
+	if _, err := os.Stat("/var/lib/cloud/instance"); err == nil {
+		t.Skip("cloud-init present")
+	}
+	stubsDir := writeInstallerStubs(t, "2.14.3-1")
+	defer os.RemoveAll(stubsDir)
+
+	installer := newAnsibleInstaller(test.GetNewRemoteSettingsWithDefaults(t, map[string]interface{}{
+		"install_version": "2.14.3",
+		"install_method":  "package",
+		"install_package": "ansible-core",
+	}))
+	installer.MarkerPath = filepath.Join(stubsDir, "marker", "installed")
+
+	if output, err := runTestInstaller(t, installer, stubsDir); err != nil {
+		t.Fatalf("Expected the installer to succeed but got: %v, %s", err, output)
+	}
+	marker, err := ioutil.ReadFile(installer.MarkerPath)
+	if err != nil {
+		t.Fatalf("Expected the marker to be written: %v", err)
+	}
+	if strings.TrimSpace(string(marker)) != "package ansible-core==2.14.3-1" {
+		t.Fatalf("Unexpected marker: %s", string(marker))
+	}
+
+	check := exec.Command("/bin/sh", "-c", installer.markerCheckCommand())
+	check.Env = append(os.Environ(), "PATH="+stubsDir+":"+os.Getenv("PATH"))
+	if err := check.Run(); err == nil || !strings.Contains(err.Error(), "exit status 50") {
+		t.Fatalf("Expected the marker check to report the installation but got: %v", err)
+	}
+
+	// a different version is installed again:
+	installer.Version = "2.15"
+	check = exec.Command("/bin/sh", "-c", installer.markerCheckCommand())
+	check.Env = append(os.Environ(), "PATH="+stubsDir+":"+os.Getenv("PATH"))
+	if err := check.Run(); err != nil {
+		t.Fatalf("Expected the marker check to require the installation but got: %v", err)
+	}
+	os.Remove(installer.MarkerPath)
+	output, err := runTestInstaller(t, installer, stubsDir)
+	if err == nil || !strings.Contains(output, "Expected ansible-core 2.15 but 2.14.3-1 is installed.") {
+		t.Fatalf("Expected the version verification to fail but got: %v, %s", err, output)
+	}
+	if _, err := os.Stat(installer.MarkerPath); err == nil {
+		t.Fatal("Did not expect the marker to be written for a failed verification")
+	}
+}
+
+func TestInstallerOfflineBundle(t *testing.T) {
+	if _, err := os.Stat("/var/lib/cloud/instance"); err == nil {
+		t.Skip("cloud-init present")
+	}
+	stubsDir := writeInstallerStubs(t, "")
+	defer os.RemoveAll(stubsDir)
+	bundleDir := writeTestUploadDir(t, map[string]string{
+		"wheels/ansible_core-2.15.0-py3-none-any.whl": "wheel",
+		"deps/PyYAML-6.0-py3-none-any.whl":            "wheel",
+		"README":                                      "bundle",
+	})
+	defer os.RemoveAll(bundleDir)
+	venvDir := filepath.Join(stubsDir, "venv")
+	pipLog := filepath.Join(stubsDir, "pip.log")
+
+	// python3 -m venv creates a venv with a python recording pip calls:
+	venvPython := "#!/bin/sh\n" +
+		"echo \"$@\" >> '" + pipLog + "'\n" +
+		"if [ \"$3\" = \"show\" ]; then echo 'Version: 2.15.0'; fi\n"
+	if err := ioutil.WriteFile(filepath.Join(stubsDir, "venv-python"), []byte(venvPython), 0755); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	python3 := "#!/bin/sh\n" +
+		"mkdir -p \"$3/bin\"\n" +
+		"cp '" + filepath.Join(stubsDir, "venv-python") + "' \"$3/bin/python\"\n" +
+		"cp '" + filepath.Join(stubsDir, "ansible-playbook") + "' \"$3/bin/ansible-playbook\"\n"
+	if err := ioutil.WriteFile(filepath.Join(stubsDir, "python3"), []byte(python3), 0755); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	installer := &ansibleInstaller{
+		Method:        remoteInstallMethodOffline,
+		Package:       "ansible-core",
+		Version:       "2.15",
+		MarkerPath:    filepath.Join(stubsDir, "marker", "installed"),
+		VenvPath:      venvDir,
+		BinDir:        filepath.Join(stubsDir, "bin"),
+		OfflineBundle: bundleDir,
+	}
+	if output, err := runTestInstaller(t, installer, stubsDir); err != nil {
+		t.Fatalf("Expected the offline installer to succeed but got: %v, %s", err, output)
+	}
+	pipCalls, err := ioutil.ReadFile(pipLog)
+	if err != nil {
+		t.Fatalf("Expected pip to be called: %v", err)
+	}
+	expected := "-m pip install --no-index --find-links " + filepath.Join(bundleDir, "deps") +
+		" --find-links " + filepath.Join(bundleDir, "wheels") + " ansible-core==2.15"
+	if !strings.Contains(string(pipCalls), expected) {
+		t.Fatalf("Expected '%s' but pip was called with:\n%s", expected, string(pipCalls))
+	}
+	if _, err := os.Lstat(filepath.Join(stubsDir, "bin", "ansible-playbook")); err != nil {
+		t.Fatal("Expected ansible programs to be linked")
+	}
+	marker, err := ioutil.ReadFile(installer.MarkerPath)
+	if err != nil || strings.TrimSpace(string(marker)) != "offline ansible-core==2.15.0" {
+		t.Fatalf("Unexpected marker: %s, %v", string(marker), err)
+	}
+}
+
+func TestInstallerCommandDoesNotRequireExecutable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "installer-directory")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	installerPath := filepath.Join(dir, "tf-ansible-installer")
+	tmpdirPath := filepath.Join(dir, "tmpdir")
+
+	// the installer is not executable and records its TMPDIR:
+	installer := "#!/bin/sh\nprintf '%s' \"$TMPDIR\" > '" + tmpdirPath + "'\nexit 3\n"
+	if err := ioutil.WriteFile(installerPath, []byte(installer), 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = exec.Command("/bin/sh", "-c", strings.TrimSuffix(strings.TrimPrefix(installerCommand(installerInterpreter([]byte(installer)), dir, installerPath), "/bin/sh -c '"), "'")).Run()
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatalf("Expected the installer exit status but got: %v", err)
+	}
+	if tmpdir, err := ioutil.ReadFile(tmpdirPath); err != nil || string(tmpdir) != dir {
+		t.Fatalf("Expected TMPDIR '%s' but got: '%s', %v", dir, string(tmpdir), err)
+	}
+	if _, err := os.Stat(installerPath); err == nil {
+		t.Fatal("Expected the installer to be removed")
+	}
+}
+
+func TestInstallerInterpreter(t *testing.T) {
+	for script, expected := range map[string]string{
+		"#!/bin/sh\nexit 0\n":                "/bin/sh",
+		"#!/usr/bin/env python3\nprint(1)\n": "/usr/bin/env python3",
+		"echo 'no shebang'\n":                "sh",
+		"#!/bin/sh -c 'rm -rf /'\nexit 0\n":  "sh",
+		"":                                   "sh",
+	} {
+		if interpreter := installerInterpreter([]byte(script)); interpreter != expected {
+			t.Fatalf("Expected '%s' for %q but got '%s'", expected, script, interpreter)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package mode
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var playLogNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// preparePlayLog creates the file Ansible logs the play to when remote.log_download_path is set,
+// empty string otherwise. The file is created by the connection user, Ansible running with the become
+// command appends to it and the file remains readable for the download.
+func (v *RemoteMode) preparePlayLog(index int) (string, error) {
+	if v.remoteSettings.LogDownloadPath() == "" {
+		return "", nil
+	}
+	logPath := filepath.Join(v.remoteSettings.BootstrapDirectory(), fmt.Sprintf("ansible-play-%d.log", index))
+	if err := v.comm.Upload(logPath, bytes.NewReader([]byte{})); err != nil {
+		return "", fmt.Errorf("failed creating the Ansible log '%s': %v", logPath, err)
+	}
+	return logPath, nil
+}
+
+// downloadPlayLog downloads the Ansible log of the play to remote.log_download_path.
+// A failed download is reported but does not fail the provisioning, the play result matters more.
+func (v *RemoteMode) downloadPlayLog(logPath string, index int, started time.Time) {
+	if logPath == "" {
+		return
+	}
+	localPath := filepath.Join(v.remoteSettings.LogDownloadPath(), playLogName(v.resourceID, v.connInfo.Host, index, started))
+	if err := v.fs.Download(logPath, localPath, 0600); err != nil {
+		v.o.Output(fmt.Sprintf("failed downloading the Ansible log '%s': %v", logPath, err))
+		return
+	}
+	v.o.Output(fmt.Sprintf("Ansible log of the play downloaded to '%s'.", localPath))
+}
+
+// playLogName returns the local file name of a play log, with the resource ID, the host when there is no ID yet,
+// and the time the play started.
+func playLogName(resourceID string, host string, index int, started time.Time) string {
+	if resourceID == "" {
+		resourceID = host
+	}
+	return fmt.Sprintf("%s-%s-play-%d.log",
+		playLogNameUnsafe.ReplaceAllString(resourceID, "_"),
+		started.UTC().Format("20060102T150405Z"),
+		index)
+}
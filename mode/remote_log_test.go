@@ -0,0 +1,93 @@
+package mode
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+)
+
+func TestPlayLogName(t *testing.T) {
+	started := time.Date(2021, 3, 4, 5, 6, 7, 0, time.FixedZone("CET", 3600))
+	if name := playLogName("i-0abc/1", "10.0.0.1", 2, started); name != "i-0abc_1-20210304T040607Z-play-2.log" {
+		t.Fatalf("Unexpected log name: %s", name)
+	}
+	if name := playLogName("", "10.0.0.1", 0, started); name != "10.0.0.1-20210304T040607Z-play-0.log" {
+		t.Fatalf("Expected the host without a resource ID but got: %s", name)
+	}
+}
+
+func TestPlayCommandLogPath(t *testing.T) {
+	v := getTestAgentRemoteMode(t, nil, true)
+	expected := `sudo env ANSIBLE_LOG_PATH="/tmp/tf-ansible-bootstrap/ansible-play-0.log" SSH_AUTH_SOCK="$SSH_AUTH_SOCK" ANSIBLE_FORCE_COLOR=true ansible-playbook site.yml`
+	if command := v.playCommand("", "ANSIBLE_FORCE_COLOR=true ansible-playbook site.yml", "/tmp/tf-ansible-bootstrap/ansible-play-0.log"); command != expected {
+		t.Fatalf("Expected:\n%s\nbut got:\n%s", expected, command)
+	}
+}
+
+func TestDownloadPlayLog(t *testing.T) {
+	output := new(terraform.MockUIOutput)
+	instanceState := test.GetNewSSHInstanceState(t, "integration-test")
+	sshServer := test.GetConfiguredAndRunningSSHServer(t, "remote-log", false, instanceState, output)
+	defer sshServer.Stop()
+
+	connInfo, err := parseConnectionInfo(instanceState)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fs, err := newSFTPRemoteFS(connInfo, newLocalShellRemoteFS())
+	if err != nil {
+		t.Fatalf("Expected an SFTP session but got: %v", err)
+	}
+	defer fs.Close()
+
+	bootstrapDirectory := test.CreateTempAnsibleBootstrapDir(t)
+	defer os.RemoveAll(bootstrapDirectory)
+	logDirectory, err := ioutil.TempDir("", "remote-log")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(logDirectory)
+
+	commandOutput := new(terraform.MockUIOutput)
+	v := &RemoteMode{
+		o:          commandOutput,
+		resourceID: "i-0abc",
+		connInfo:   connInfo,
+		fs:         fs,
+		remoteSettings: test.GetNewRemoteSettingsWithDefaults(t, map[string]interface{}{
+			"bootstrap_directory": bootstrapDirectory,
+			"log_download_path":   filepath.Join(logDirectory, "logs"),
+		}),
+	}
+
+	// the log Ansible wrote on the host:
+	logPath := filepath.Join(bootstrapDirectory, "ansible-play-1.log")
+	if err := ioutil.WriteFile(logPath, []byte("TASK [Gathering Facts]\n"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	started := time.Now()
+	v.downloadPlayLog(logPath, 1, started)
+
+	localPath := filepath.Join(logDirectory, "logs", playLogName("i-0abc", connInfo.Host, 1, started))
+	data, err := ioutil.ReadFile(localPath)
+	if err != nil || string(data) != "TASK [Gathering Facts]\n" {
+		t.Fatalf("Expected the downloaded log but got: '%s', %v", string(data), err)
+	}
+	if info, err := os.Stat(localPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected a private log but got: %v, %v", info.Mode(), err)
+	}
+
+	// a missing log is reported, the play result is what fails the provisioning:
+	v.downloadPlayLog(filepath.Join(bootstrapDirectory, "ansible-play-2.log"), 2, started)
+	commandOutput.Lock()
+	defer commandOutput.Unlock()
+	if !strings.HasPrefix(commandOutput.OutputMessage, "failed downloading the Ansible log") {
+		t.Fatalf("Expected the failed download to be reported but got: %s", commandOutput.OutputMessage)
+	}
+}
@@ -0,0 +1,43 @@
+package mode
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+	"github.com/radekg/terraform-provisioner-ansible/v2/types"
+)
+
+func TestBecomeCommandNetworkEnvironment(t *testing.T) {
+	network := schema.TestResourceDataRaw(t, map[string]*schema.Schema{
+		"network": types.NewRemoteNetworkSchema(),
+	}, map[string]interface{}{
+		"network": []interface{}{
+			map[string]interface{}{
+				"https_proxy":   "http://proxy.internal:3128",
+				"no_proxy":      "localhost,.internal",
+				"pip_index_url": "https://pypi.internal/simple?token='x'",
+			},
+		},
+	})
+	remoteSettings := test.GetNewRemoteSettingsWithDefaults(t, map[string]interface{}{
+		"network": network.Get("network"),
+	})
+	v := &RemoteMode{remoteSettings: remoteSettings, remoteCABundle: "/tmp/tf-ansible-bootstrap/network-ca-bundle.pem"}
+
+	expected := "sudo env HTTPS_PROXY='http://proxy.internal:3128' NO_PROXY='localhost,.internal'" +
+		" PIP_CERT='/tmp/tf-ansible-bootstrap/network-ca-bundle.pem'" +
+		" PIP_INDEX_URL='https://pypi.internal/simple?token='\\''x'\\'''" +
+		" REQUESTS_CA_BUNDLE='/tmp/tf-ansible-bootstrap/network-ca-bundle.pem'" +
+		" SSL_CERT_FILE='/tmp/tf-ansible-bootstrap/network-ca-bundle.pem'" +
+		" https_proxy='http://proxy.internal:3128' no_proxy='localhost,.internal'" +
+		" ansible-galaxy install --role-file=requirements.yml"
+	if command := v.becomeCommand("ansible-galaxy install --role-file=requirements.yml"); command != expected {
+		t.Fatalf("Expected:\n%s\nbut got:\n%s", expected, command)
+	}
+
+	v = &RemoteMode{remoteSettings: test.GetNewRemoteSettingsWithDefaults(t, nil)}
+	if command := v.becomeCommand("ansible-playbook site.yml"); command != "sudo ansible-playbook site.yml" {
+		t.Fatalf("Expected no environment without network settings but got: %s", command)
+	}
+}
@@ -0,0 +1,208 @@
+package mode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/radekg/terraform-provisioner-ansible/v2/shellescape"
+	"github.com/radekg/terraform-provisioner-ansible/v2/types"
+)
+
+const (
+	// exit codes of the executable directory command:
+	directoryNotWritableExitCode = 70
+	directoryNoExecExitCode      = 71
+	// exit codes of the preflight commands:
+	preflightPythonMissingExitCode  = 72
+	preflightAnsibleMissingExitCode = 73
+	preflightGitMissingExitCode     = 74
+	// uploads and the installation need at least this much free space in the bootstrap directory:
+	preflightMinFreeDiskKB = 256 * 1024
+)
+
+// preflight checks the host before anything is uploaded or installed, prints the report
+// and fails with every problem found.
+func (v *RemoteMode) preflight() error {
+	become := func(command string) error {
+		return v.execCommand(command, v.becomeStdin())
+	}
+	report, problems, err := remotePreflightChecks(v.runCommandOutput, become, v.remoteSettings)
+	if err != nil {
+		return err
+	}
+	_, shell := v.fs.(*shellRemoteFS)
+	downloadReport, downloadProblems := downloadPreflightChecks(v.remoteSettings, !shell)
+	report = append(report, downloadReport...)
+	problems = append(problems, downloadProblems...)
+	v.o.Output("Preflight checks:")
+	for _, line := range report {
+		v.o.Output(fmt.Sprintf("  %s", line))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("preflight checks failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// remotePreflightChecks runs the preflight commands with the given functions, become runs the become command check
+// with the become password as input. The report has a line per check. Problems are returned for the checks
+// which would make the provisioning fail later.
+func remotePreflightChecks(output func(command string) (string, error), become func(command string) error, remoteSettings *types.RemoteSettings) ([]string, []string, error) {
+	report := make([]string, 0)
+	problems := make([]string, 0)
+	dir := remoteSettings.BootstrapDirectory()
+
+	if _, err := output(executableDirectoryCommand(dir)); err != nil {
+		status, ok := remoteExitStatus(err)
+		switch {
+		case ok && status == directoryNotWritableExitCode:
+			report = append(report, fmt.Sprintf("bootstrap directory '%s': not writable", dir))
+			problems = append(problems, fmt.Sprintf("the bootstrap directory '%s' is not writable by the connection user", dir))
+		case ok && status == directoryNoExecExitCode:
+			// nothing is executed from the bootstrap directory, the installer directory is checked before the installation:
+			report = append(report, fmt.Sprintf("bootstrap directory '%s': writable, mounted noexec", dir))
+		default:
+			return nil, nil, err
+		}
+	} else {
+		report = append(report, fmt.Sprintf("bootstrap directory '%s': writable, allows execution", dir))
+	}
+
+	freeKB, err := output(fmt.Sprintf("df -Pk \"%s\" | awk 'NR==2 {print $4}'", dir))
+	if _, ok := remoteExitStatus(err); err != nil && !ok {
+		return nil, nil, err
+	}
+	if kb, err := strconv.ParseInt(strings.TrimSpace(freeKB), 10, 64); err == nil {
+		report = append(report, fmt.Sprintf("free disk space: %d MiB", kb/1024))
+		if kb < preflightMinFreeDiskKB {
+			problems = append(problems, fmt.Sprintf("only %d MiB free in '%s', at least %d MiB required", kb/1024, dir, preflightMinFreeDiskKB/1024))
+		}
+	} else {
+		report = append(report, "free disk space: unknown")
+	}
+
+	if remoteSettings.UseSudo() {
+		name := remoteSettings.BecomeCommand()
+		if command := preflightBecomeCommand(remoteSettings); command == "" {
+			report = append(report, fmt.Sprintf("%s: not checked, it can not run without a terminal", name))
+		} else if err := become(command); err != nil {
+			if _, ok := remoteExitStatus(err); !ok {
+				return nil, nil, err
+			}
+			if remoteSettings.BecomePassword() != "" {
+				report = append(report, fmt.Sprintf("%s: the password is not accepted", name))
+				problems = append(problems, fmt.Sprintf("%s does not accept remote.become_password or requires a TTY", name))
+			} else {
+				report = append(report, fmt.Sprintf("%s: requires a password or a TTY", name))
+				problems = append(problems, fmt.Sprintf("%s requires a password or a TTY, allow it without a password and without requiretty for the connection user, give remote.become_password or set remote.use_sudo = false", name))
+			}
+		} else {
+			report = append(report, fmt.Sprintf("%s: works without a TTY", name))
+		}
+	}
+
+	python, err := output(fmt.Sprintf("command -v python3 || command -v python || exit %d", preflightPythonMissingExitCode))
+	if status, ok := remoteExitStatus(err); ok && status == preflightPythonMissingExitCode {
+		switch {
+		case remoteSettings.SkipInstall():
+			report = append(report, "python: not found")
+			problems = append(problems, "no Python interpreter found, Ansible requires Python on the host and skip_install is set")
+		case remoteSettings.OfflineBundle() != "":
+			report = append(report, "python: not found")
+			problems = append(problems, "no Python interpreter found, the offline installation requires python3 with the venv module")
+		default:
+			report = append(report, "python: not found, the installer installs it")
+		}
+	} else if err != nil {
+		return nil, nil, err
+	} else {
+		report = append(report, fmt.Sprintf("python: %s", preflightFound(python)))
+	}
+
+	ansiblePlaybook, err := output(fmt.Sprintf("command -v ansible-playbook || exit %d", preflightAnsibleMissingExitCode))
+	if status, ok := remoteExitStatus(err); ok && status == preflightAnsibleMissingExitCode {
+		if remoteSettings.SkipInstall() {
+			report = append(report, "ansible-playbook: not found")
+			problems = append(problems, "ansible-playbook not found on the PATH and skip_install is set")
+		} else {
+			report = append(report, "ansible-playbook: not found, the installer installs it")
+		}
+	} else if err != nil {
+		return nil, nil, err
+	} else {
+		report = append(report, fmt.Sprintf("ansible-playbook: %s", preflightFound(ansiblePlaybook)))
+	}
+
+	if remoteSettings.Pull().InUse() {
+		git, err := output(fmt.Sprintf("command -v git || exit %d", preflightGitMissingExitCode))
+		if status, ok := remoteExitStatus(err); ok && status == preflightGitMissingExitCode {
+			report = append(report, "git: not found")
+			problems = append(problems, "git not found on the PATH, ansible-pull clones the remote.pull repository with git")
+		} else if err != nil {
+			return nil, nil, err
+		} else {
+			report = append(report, fmt.Sprintf("git: %s", preflightFound(git)))
+		}
+	}
+
+	return report, problems, nil
+}
+
+// downloadPreflightChecks checks the host offers SFTP when files are downloaded from it, remote.fetch
+// and remote.log_download_path download over SFTP only. Nothing is reported without downloads.
+func downloadPreflightChecks(remoteSettings *types.RemoteSettings, sftp bool) ([]string, []string) {
+	report := make([]string, 0)
+	problems := make([]string, 0)
+	if len(remoteSettings.Fetch()) == 0 && remoteSettings.LogDownloadPath() == "" {
+		return report, problems
+	}
+	if sftp {
+		report = append(report, "sftp: available for downloads")
+		return report, problems
+	}
+	report = append(report, "sftp: not available")
+	if len(remoteSettings.Fetch()) > 0 {
+		problems = append(problems, "the host does not offer the sftp subsystem, remote.fetch downloads over SFTP")
+	}
+	if remoteSettings.LogDownloadPath() != "" {
+		problems = append(problems, "the host does not offer the sftp subsystem, remote.log_download_path downloads over SFTP")
+	}
+	return report, problems
+}
+
+// executableDirectoryCommand returns a command writing a program to the directory and executing it,
+// exiting with directoryNotWritableExitCode or directoryNoExecExitCode.
+func executableDirectoryCommand(dir string) string {
+	return fmt.Sprintf("/bin/sh -c 'probe=\"%s/.tf-ansible-preflight-$$\"; printf \"#!/bin/sh\\nexit 0\\n\" > \"$probe\" 2>/dev/null || exit %d; chmod 0700 \"$probe\" && \"$probe\" 2>/dev/null; status=$?; rm -f \"$probe\"; [ $status -eq 0 ] || exit %d'",
+		dir,
+		directoryNotWritableExitCode,
+		directoryNoExecExitCode)
+}
+
+// preflightBecomeCommand returns a command checking the become command works without a terminal,
+// empty string when the become command can not be checked.
+func preflightBecomeCommand(remoteSettings *types.RemoteSettings) string {
+	user := ""
+	if remoteSettings.BecomeUser() != "" {
+		user = fmt.Sprintf("-u '%s' ", shellescape.NewSingleQuoteEscape(remoteSettings.BecomeUser()).Safe())
+	}
+	switch remoteSettings.BecomeCommand() {
+	case types.RemoteBecomeSudo:
+		if remoteSettings.BecomePassword() != "" {
+			// cached credentials would accept any password:
+			return fmt.Sprintf("sudo -S -k -p '' %strue", user)
+		}
+		return fmt.Sprintf("sudo -n %strue", user)
+	case types.RemoteBecomeDoas:
+		return fmt.Sprintf("doas -n %strue", user)
+	}
+	return ""
+}
+
+func preflightFound(path string) string {
+	if strings.TrimSpace(path) == "" {
+		return "found"
+	}
+	return strings.TrimSpace(path)
+}
@@ -0,0 +1,150 @@
+package mode
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+	"github.com/radekg/terraform-provisioner-ansible/v2/types"
+)
+
+func getTestPreflightRemoteSettings(t *testing.T, bootstrapDirectory string, skipInstall bool) *types.RemoteSettings {
+	remoteSettings := test.GetNewRemoteSettingsWithDefaults(t, map[string]interface{}{
+		"use_sudo":     false,
+		"skip_install": skipInstall,
+	})
+	remoteSettings.SetOverrideBootstrapDirectory(bootstrapDirectory)
+	return remoteSettings
+}
+
+func TestRemotePreflightChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preflight")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	report, problems, err := remotePreflightChecks(newLocalShellRemoteFS().output, newLocalShellRemoteFS().run, getTestPreflightRemoteSettings(t, dir, false))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(report) != 4 || report[0] != "bootstrap directory '"+dir+"': writable, allows execution" {
+		t.Fatalf("Unexpected report: %v", report)
+	}
+	for _, problem := range problems {
+		// the only problem a development machine may have:
+		if !strings.HasPrefix(problem, "only ") {
+			t.Fatalf("Unexpected problem: %s", problem)
+		}
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) > 0 {
+		t.Fatalf("Expected the probe to be removed but got: %v, %v", entries, err)
+	}
+
+	// only the basic tools on the PATH, Ansible can not run without the installer:
+	stubsDir, err := ioutil.TempDir("", "preflight-path")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(stubsDir)
+	for _, program := range []string{"chmod", "rm", "df", "awk"} {
+		programPath, err := exec.LookPath(program)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := os.Symlink(programPath, filepath.Join(stubsDir, program)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", stubsDir)
+	defer os.Setenv("PATH", path)
+	_, problems, err = remotePreflightChecks(newLocalShellRemoteFS().output, newLocalShellRemoteFS().run, getTestPreflightRemoteSettings(t, dir, true))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{
+		"no Python interpreter found, Ansible requires Python on the host and skip_install is set",
+		"ansible-playbook not found on the PATH and skip_install is set",
+	}
+	if strings.Join(problems, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected problems:\n%s\nbut got:\n%s", strings.Join(expected, "\n"), strings.Join(problems, "\n"))
+	}
+
+	// a missing directory can not be written to:
+	_, problems, err = remotePreflightChecks(newLocalShellRemoteFS().output, newLocalShellRemoteFS().run, getTestPreflightRemoteSettings(t, dir+"/missing", true))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(problems) == 0 || !strings.Contains(problems[0], "is not writable by the connection user") {
+		t.Fatalf("Expected the directory not to be writable but got: %v", problems)
+	}
+}
+
+func TestRemotePreflightNoexecBootstrapDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preflight")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the probe can not be executed, like in a directory mounted noexec:
+	output := func(command string) (string, error) {
+		if command == executableDirectoryCommand(dir) {
+			return "", &remoteCommandError{command: command, exitStatus: directoryNoExecExitCode}
+		}
+		return newLocalShellRemoteFS().output(command)
+	}
+	report, problems, err := remotePreflightChecks(output, newLocalShellRemoteFS().run, getTestPreflightRemoteSettings(t, dir, false))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if report[0] != "bootstrap directory '"+dir+"': writable, mounted noexec" {
+		t.Fatalf("Expected the noexec directory to be reported but got: %v", report)
+	}
+	for _, problem := range problems {
+		if !strings.HasPrefix(problem, "only ") {
+			t.Fatalf("Expected noexec not to be a problem but got: %s", problem)
+		}
+	}
+}
+
+func TestRemotePreflightDownloadsRequireSFTP(t *testing.T) {
+	fetch := schema.TestResourceDataRaw(t, map[string]*schema.Schema{
+		"fetch": types.NewRemoteFetchSchema(),
+	}, map[string]interface{}{
+		"fetch": []interface{}{
+			map[string]interface{}{
+				"source":      "/etc/kubernetes/admin.conf",
+				"destination": "./kubeconfig",
+			},
+		},
+	})
+
+	// nothing is downloaded, SFTP is not required:
+	report, problems := downloadPreflightChecks(test.GetNewRemoteSettingsWithDefaults(t, nil), false)
+	if len(report) != 0 || len(problems) != 0 {
+		t.Fatalf("Unexpected report: %v, problems: %v", report, problems)
+	}
+
+	remoteSettings := test.GetNewRemoteSettingsWithDefaults(t, map[string]interface{}{
+		"fetch":             fetch.Get("fetch"),
+		"log_download_path": "./logs",
+	})
+	report, problems = downloadPreflightChecks(remoteSettings, true)
+	if len(report) != 1 || len(problems) != 0 {
+		t.Fatalf("Unexpected report: %v, problems: %v", report, problems)
+	}
+	// without SFTP, the run fails before the plays instead of after them:
+	report, problems = downloadPreflightChecks(remoteSettings, false)
+	if len(report) != 1 || report[0] != "sftp: not available" || len(problems) != 2 ||
+		!strings.Contains(problems[0], "remote.fetch") || !strings.Contains(problems[1], "remote.log_download_path") {
+		t.Fatalf("Unexpected report: %v, problems: %v", report, problems)
+	}
+}
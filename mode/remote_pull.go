@@ -0,0 +1,102 @@
+package mode
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/radekg/terraform-provisioner-ansible/v2/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+// deployPullPlaybook prepares a playbook play running with ansible-pull. Nothing of the playbook is uploaded,
+// ansible-pull clones the repository on the host. The inventory, the Vault files and the deploy key
+// are uploaded to a directory of the play.
+func (v *RemoteMode) deployPullPlaybook(play *types.Play, remotePlayDir string) error {
+	if err := v.fs.MkdirAll(remotePlayDir); err != nil {
+		return err
+	}
+
+	if err := v.uploadPullDeployKey(play, remotePlayDir); err != nil {
+		return err
+	}
+
+	// always create temp inventory:
+	inventoryFile, err := v.writeInventory(remotePlayDir, play)
+	if err != nil {
+		return err
+	}
+	play.SetOverrideInventoryFile(inventoryFile)
+
+	// always handle Vault ID or password file
+	return v.uploadVaultFiles(play, remotePlayDir)
+}
+
+// toRemoteCommand returns the command running the play on the host, playbooks run with ansible-pull
+// when remote.pull is set.
+func (v *RemoteMode) toRemoteCommand(play *types.Play) (string, error) {
+	playbook, ok := play.Entity().(*types.Playbook)
+	if !ok || !v.remoteSettings.Pull().InUse() {
+		return play.ToCommand(types.LocalModeAnsibleArgs{Username: v.connInfo.User})
+	}
+	return play.ToPullCommand(types.RemotePullArgs{
+		URL:            v.remoteSettings.Pull().Repository(),
+		Checkout:       v.remoteSettings.Pull().Ref(),
+		Directory:      filepath.Join(v.remoteSettings.BootstrapDirectory(), "pull"),
+		PrivateKeyFile: v.pullDeployKeys[play],
+		AcceptHostKey:  v.remoteSettings.Pull().AcceptHostKey(),
+		Playbook:       pullPlaybookPath(playbook),
+	})
+}
+
+// checkPullPlays rejects, before anything runs on the host, the playbook plays setting the arguments
+// ansible-pull does not pass to the playbook.
+func (v *RemoteMode) checkPullPlays(plays []*types.Play) error {
+	if !v.remoteSettings.Pull().InUse() {
+		return nil
+	}
+	for i, play := range plays {
+		if _, ok := play.Entity().(*types.Playbook); !ok {
+			continue
+		}
+		if unsupported := play.UnsupportedPullArguments(); len(unsupported) > 0 {
+			return fmt.Errorf("play %d runs with remote.pull, ansible-pull does not pass %s to the playbook", i, strings.Join(unsupported, ", "))
+		}
+	}
+	return nil
+}
+
+// uploadPullDeployKey uploads the deploy key of remote.pull to the directory of the play,
+// nothing is uploaded when there is no deploy key. The key is removed after the play.
+func (v *RemoteMode) uploadPullDeployKey(play *types.Play, remotePlayDir string) error {
+	deployKey := v.remoteSettings.Pull().DeployKey()
+	if deployKey == "" {
+		return nil
+	}
+	// ssh does not read a key without the trailing new line:
+	if !strings.HasSuffix(deployKey, "\n") {
+		deployKey = deployKey + "\n"
+	}
+	targetPath := filepath.Join(remotePlayDir, fmt.Sprintf(".pull-key-%s", uuid.NewV4()))
+
+	v.o.Output(fmt.Sprintf("Uploading the remote.pull deploy key to '%s'...", targetPath))
+	if err := v.comm.Upload(targetPath, bytes.NewReader([]byte(deployKey))); err != nil {
+		return err
+	}
+	v.addSensitiveFile(play, targetPath)
+	// ssh refuses a private key readable by others:
+	if err := v.runCommandNoSudo(fmt.Sprintf("chmod 0600 \"%s\"", targetPath)); err != nil {
+		return err
+	}
+	if v.pullDeployKeys == nil {
+		v.pullDeployKeys = make(map[*types.Play]string)
+	}
+	v.pullDeployKeys[play] = targetPath
+	return nil
+}
+
+// pullPlaybookPath returns the path of the playbook in the repository, the file_path of the playbook.
+func pullPlaybookPath(playbook *types.Playbook) string {
+	return strings.TrimPrefix(filepath.ToSlash(playbook.FilePath()), "./")
+}
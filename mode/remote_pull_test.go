@@ -0,0 +1,253 @@
+package mode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+	"github.com/radekg/terraform-provisioner-ansible/v2/types"
+)
+
+// testPullAnsiblePull stands in for ansible-pull when Ansible is not installed, it clones the repository
+// like ansible-pull does and checks the playbook is in the checkout.
+const testPullAnsiblePull = `#!/bin/sh
+for arg in "$@"; do
+  case "$arg" in
+    --url=*) url="${arg#--url=}" ;;
+    --directory=*) directory="${arg#--directory=}" ;;
+    --checkout=*) checkout="${arg#--checkout=}" ;;
+  esac
+  playbook="$arg"
+done
+git clone --quiet ${checkout:+--branch "$checkout"} "$url" "$directory" && test -f "$directory/$playbook"
+`
+
+func getTestPullRemoteSettings(t *testing.T, bootstrapDirectory string, pull map[string]interface{}) *types.RemoteSettings {
+	pullRawConfigs := schema.TestResourceDataRaw(t, map[string]*schema.Schema{
+		"pull": types.NewRemotePullSchema(),
+	}, map[string]interface{}{
+		"pull": []interface{}{pull},
+	})
+	return test.GetNewRemoteSettingsWithDefaults(t, map[string]interface{}{
+		"install_version":            "ansible@integration-test",
+		"remote_installer_directory": bootstrapDirectory,
+		"bootstrap_directory":        bootstrapDirectory,
+		"pull":                       pullRawConfigs.Get("pull"),
+	})
+}
+
+func getTestPlaybookPlay(t *testing.T, playbookFilePath string, raw map[string]interface{}) *types.Play {
+	defaultSettings := test.GetDefaultSettingsForUser(t, test.GetCurrentUser(t))
+	playPlaybookRawConfigs := test.GetPlayPlaybookSchema(t, playbookFilePath)
+	playPlaybook := map[string]interface{}{
+		"enabled":             true,
+		"become":              false,
+		"become_method":       defaultSettings.BecomeMethod(),
+		"become_user":         defaultSettings.BecomeUser(),
+		"diff":                false,
+		"check":               false,
+		"forks":               5,
+		"inventory_file":      "",
+		"limit":               "",
+		"vault_id":            []interface{}{},
+		"vault_password_file": "",
+		"verbose":             false,
+		"extra_vars":          map[string]interface{}{},
+		"module":              playPlaybookRawConfigs.Get("module").(*schema.Set),
+		"playbook":            playPlaybookRawConfigs.Get("playbook").(*schema.Set),
+	}
+	for k, v := range raw {
+		playPlaybook[k] = v
+	}
+	return types.NewPlayFromMapInterface(playPlaybook, defaultSettings)
+}
+
+// createTestPullRepository creates a bare repository with site.yml on the main and the release branches.
+func createTestPullRepository(t *testing.T, dir string) string {
+	repository := filepath.Join(dir, "playbooks.git")
+	work := filepath.Join(dir, "work")
+	git := func(args ...string) {
+		command := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		command.Dir = work
+		if out, err := command.CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %v: %s", strings.Join(args, " "), err, string(out))
+		}
+	}
+	commit := func(branch string, playbook string) {
+		if err := ioutil.WriteFile(filepath.Join(work, "site.yml"), []byte(playbook), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		git("add", "site.yml")
+		git("commit", "--quiet", "-m", branch)
+		git("push", "--quiet", repository, fmt.Sprintf("HEAD:refs/heads/%s", branch))
+	}
+	if err := os.MkdirAll(work, 0755); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	git("init", "--quiet", "--bare", repository)
+	git("init", "--quiet")
+	commit("main", "- hosts: all\n  gather_facts: false\n  tasks: []\n")
+	commit("release", "- hosts: all\n  gather_facts: false\n  tasks:\n  - debug: msg=release\n")
+	return repository
+}
+
+func TestPullPlaybookPath(t *testing.T) {
+	for _, tc := range []struct {
+		filePath string
+		expected string
+	}{
+		{"site.yml", "site.yml"},
+		{"./site/main.yml", "site/main.yml"},
+		{"playbooks/web.yml", "playbooks/web.yml"},
+	} {
+		playbook := getTestPlaybookPlay(t, tc.filePath, nil).Entity().(*types.Playbook)
+		if path := pullPlaybookPath(playbook); path != tc.expected {
+			t.Fatalf("Expected playbook path '%s' but got: '%s'", tc.expected, path)
+		}
+	}
+}
+
+func TestPullRejectsUnsupportedArguments(t *testing.T) {
+	v := &RemoteMode{
+		remoteSettings: getTestPullRemoteSettings(t, "/tmp", map[string]interface{}{
+			"repository": "file:///srv/playbooks.git",
+		}),
+	}
+	if err := v.checkPullPlays([]*types.Play{getTestPlaybookPlay(t, "site.yml", nil)}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err := v.checkPullPlays([]*types.Play{
+		getTestPlaybookPlay(t, "site.yml", nil),
+		getTestPlaybookPlay(t, "site.yml", map[string]interface{}{"become": true, "forks": 10}),
+	})
+	if err == nil || !strings.Contains(err.Error(), "play 1 ") || !strings.Contains(err.Error(), "become, forks") {
+		t.Fatalf("Expected the second play to be rejected but got: %v", err)
+	}
+}
+
+func TestPullFromLocalRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "pull")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	repository := createTestPullRepository(t, dir)
+
+	if _, err := exec.LookPath("ansible-pull"); err != nil {
+		stubsDir := filepath.Join(dir, "bin")
+		if err := os.MkdirAll(stubsDir, 0755); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(stubsDir, "ansible-pull"), []byte(testPullAnsiblePull), 0755); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		path := os.Getenv("PATH")
+		os.Setenv("PATH", stubsDir+string(os.PathListSeparator)+path)
+		defer os.Setenv("PATH", path)
+	}
+
+	inventory := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(inventory, []byte("localhost ansible_connection=local\n"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	v := &RemoteMode{
+		remoteSettings: getTestPullRemoteSettings(t, filepath.Join(dir, "bootstrap"), map[string]interface{}{
+			"repository": "file://" + repository,
+			"ref":        "release",
+		}),
+	}
+	bootstrapDirectory := v.remoteSettings.BootstrapDirectory()
+	// the playbook exists only in the repository:
+	play := getTestPlaybookPlay(t, "site.yml", nil)
+	play.SetOverrideInventoryFile(inventory)
+
+	command, err := v.toRemoteCommand(play)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(command, fmt.Sprintf("ansible-pull --url='file://%s' --directory='%s/pull' --checkout='release'", repository, bootstrapDirectory)) {
+		t.Fatalf("Unexpected command: %s", command)
+	}
+	if err := newLocalShellRemoteFS().run(command); err != nil {
+		t.Fatalf("Expected the play to be pulled but got: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(bootstrapDirectory, "pull", "site.yml"))
+	if err != nil || !strings.Contains(string(data), "msg=release") {
+		t.Fatalf("Expected the playbook of the release branch but got: '%s', %v", string(data), err)
+	}
+}
+
+func TestIntegrationRemoteModePull(t *testing.T) {
+	bootstrapDirectory := test.CreateTempAnsibleBootstrapDir(t)
+	defer os.RemoveAll(bootstrapDirectory)
+	sshUsername := "integration-test"
+
+	output := new(terraform.MockUIOutput)
+	instanceState := test.GetNewSSHInstanceState(t, sshUsername)
+	sshServer := test.GetConfiguredAndRunningSSHServer(t, "remote-pull", false, instanceState, output)
+	defer sshServer.Stop()
+
+	tempVaultIDFilePath := test.WriteTempVaultIDFile(t, "test-password")
+	defer os.Remove(tempVaultIDFilePath)
+	remoteSettings := getTestPullRemoteSettings(t, bootstrapDirectory, map[string]interface{}{
+		"repository": "file:///srv/playbooks.git",
+		"deploy_key": test.TestSSHUserKeyPrivate,
+	})
+	modeRemote, err := NewRemoteMode(output, instanceState, remoteSettings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runErr := modeRemote.Run([]*types.Play{
+			// the playbook exists only in the repository:
+			getTestPlaybookPlay(t, "site.yml", map[string]interface{}{
+				"vault_id": []interface{}{tempVaultIDFilePath},
+			}),
+		})
+		if runErr != nil {
+			t.Errorf("Unexpected error: %v", runErr)
+		}
+	}()
+
+	// preflight checks, git is required:
+	test.CommandTest(t, sshServer, "/bin/sh -c 'probe=")
+	test.CommandTest(t, sshServer, fmt.Sprintf("df -Pk \"%s", bootstrapDirectory))
+	test.CommandTest(t, sshServer, "sudo -n true")
+	test.CommandTest(t, sshServer, "command -v python3")
+	test.CommandTest(t, sshServer, "command -v ansible-playbook")
+	test.CommandTest(t, sshServer, "command -v git")
+
+	// the playbook is not uploaded, only the deploy key, the inventory and the vault ID:
+	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", bootstrapDirectory))
+	test.CommandTest(t, sshServer, fmt.Sprintf("chmod 0600 \"%s", bootstrapDirectory))
+	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", bootstrapDirectory))
+	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", bootstrapDirectory))
+
+	// install ansible:
+	test.CommandTest(t, sshServer, "/bin/sh -c 'case")
+	test.CommandTest(t, sshServer, "/bin/sh -c 'probe=")
+	test.CommandTest(t, sshServer, fmt.Sprintf("scp -vt %s", bootstrapDirectory))
+	test.CommandTest(t, sshServer, "sudo /bin/sh -c 'TMPDIR=")
+
+	// pull the playbook:
+	test.CommandTest(t, sshServer, "sudo ANSIBLE_FORCE_COLOR=true ansible-pull --url='file:///srv/playbooks.git'")
+	// the vault ID and the deploy key are removed right after the play:
+	test.CommandTest(t, sshServer, fmt.Sprintf("shred -u \"%s", bootstrapDirectory))
+
+	wg.Wait()
+}
@@ -0,0 +1,428 @@
+package mode
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// uploads are verified with sha256sum, the manifest is in the sha256sum format:
+	uploadManifestExtension = "sha256"
+	// the permission bits of the files follow the digests on comment lines, sha256sum never reads them:
+	uploadManifestModePrefix = "#mode "
+	// points to the content hash of the last upload of a local directory:
+	uploadLatestExtension = "latest"
+	// exit code of the verification command when the remote copy matches its manifest:
+	uploadVerifiedExitCode = 50
+)
+
+type uploadManifestEntry struct {
+	path   string
+	digest string
+	mode   os.FileMode
+}
+
+// uploadManifest lists the files of a directory with their sha256 digests and permission bits.
+type uploadManifest struct {
+	entries []uploadManifestEntry
+}
+
+// newLocalUploadManifest builds the manifest of a local directory, excluded files are not listed.
+// As with gitignore, files in an excluded directory can not be included again.
+func newLocalUploadManifest(dir string, exclude *uploadExcludeMatcher) (*uploadManifest, error) {
+	manifest := &uploadManifest{entries: make([]uploadManifestEntry, 0)}
+	if err := manifest.addDirectory(dir, "", exclude, make(map[string]bool)); err != nil {
+		return nil, err
+	}
+	sort.Slice(manifest.entries, func(i, j int) bool {
+		return manifest.entries[i].path < manifest.entries[j].path
+	})
+	return manifest, nil
+}
+
+// addDirectory lists the files of a local directory under the relative directory. Symbolic links are followed,
+// the linked files and directories are uploaded as files and directories. A broken link or a link to a directory
+// containing it fails, the upload would miss files otherwise.
+func (m *uploadManifest) addDirectory(dir string, relDir string, exclude *uploadExcludeMatcher, ancestors map[string]bool) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if ancestors[realDir] {
+		return fmt.Errorf("the symbolic link '%s' points to a directory containing it and can not be uploaded", dir)
+	}
+	ancestors[realDir] = true
+	defer delete(ancestors, realDir)
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		filePath := filepath.Join(dir, info.Name())
+		relPath := path.Join(relDir, info.Name())
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(filePath)
+			if err != nil {
+				if exclude.Excluded(relPath, false) {
+					continue
+				}
+				return fmt.Errorf("the symbolic link '%s' is broken and can not be uploaded: %v", filePath, err)
+			}
+			info = target
+		}
+		if exclude.Excluded(relPath, info.IsDir()) {
+			continue
+		}
+		switch {
+		case info.IsDir():
+			if err := m.addDirectory(filePath, relPath, exclude, ancestors); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			digest, err := fileSHA256(filePath)
+			if err != nil {
+				return err
+			}
+			m.entries = append(m.entries, uploadManifestEntry{path: relPath, digest: digest, mode: info.Mode().Perm()})
+		}
+	}
+	return nil
+}
+
+// parseUploadManifest reads a manifest in the sha256sum format with the permission bits on comment lines.
+func parseUploadManifest(text string) *uploadManifest {
+	manifest := &uploadManifest{entries: make([]uploadManifestEntry, 0)}
+	modes := make(map[string]os.FileMode)
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, uploadManifestModePrefix) {
+			fields := strings.SplitN(strings.TrimPrefix(line, uploadManifestModePrefix), " ", 2)
+			if len(fields) != 2 {
+				continue
+			}
+			if mode, err := strconv.ParseUint(fields[0], 8, 32); err == nil {
+				modes[strings.TrimPrefix(fields[1], "./")] = os.FileMode(mode)
+			}
+			continue
+		}
+		fields := strings.SplitN(line, "  ", 2)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			continue
+		}
+		manifest.entries = append(manifest.entries, uploadManifestEntry{
+			path:   strings.TrimPrefix(fields[1], "./"),
+			digest: fields[0],
+		})
+	}
+	for i := range manifest.entries {
+		manifest.entries[i].mode = modes[manifest.entries[i].path]
+	}
+	return manifest
+}
+
+// Paths returns the relative paths of the files in the manifest.
+func (m *uploadManifest) Paths() []string {
+	paths := make([]string, 0)
+	for _, entry := range m.entries {
+		paths = append(paths, entry.path)
+	}
+	return paths
+}
+
+// String returns the manifest in the sha256sum format followed by the permission bits of the files,
+// empty for a directory without files.
+func (m *uploadManifest) String() string {
+	if len(m.entries) == 0 {
+		return ""
+	}
+	lines := make([]string, 0)
+	for _, entry := range m.entries {
+		lines = append(lines, fmt.Sprintf("%s  ./%s", entry.digest, entry.path))
+	}
+	for _, entry := range m.entries {
+		lines = append(lines, fmt.Sprintf("%s%04o ./%s", uploadManifestModePrefix, entry.mode, entry.path))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Hash returns the content hash of the directory described by the manifest, the permission bits included.
+func (m *uploadManifest) Hash() string {
+	sum := sha256.Sum256([]byte(m.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// Changes returns the files which are new, changed or have changed permission bits and the files
+// which no longer exist when compared with a previous manifest.
+func (m *uploadManifest) Changes(previous *uploadManifest) (changed []string, removed []string) {
+	previousEntries := make(map[string]uploadManifestEntry)
+	for _, entry := range previous.entries {
+		previousEntries[entry.path] = entry
+	}
+	for _, entry := range m.entries {
+		if previousEntry, ok := previousEntries[entry.path]; !ok || previousEntry.digest != entry.digest || previousEntry.mode != entry.mode {
+			changed = append(changed, entry.path)
+		}
+		delete(previousEntries, entry.path)
+	}
+	for filePath := range previousEntries {
+		removed = append(removed, filePath)
+	}
+	sort.Strings(removed)
+	return changed, removed
+}
+
+func fileSHA256(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// uploadDirContentAddressed uploads a local directory to a bootstrap directory named by the hash
+// of its contents. A remote copy is reused only when it matches its manifest. Otherwise, if a previous
+// upload of the same local directory is available, it is copied and only changed files are transferred.
+// Files are transferred as a single archive. Files matching the exclude patterns of the remote settings,
+// the .ansibleignore file of the directory or the given play patterns are not uploaded.
+func (v *RemoteMode) uploadDirContentAddressed(localDir string, excludePatterns []string) (string, error) {
+	exclude, err := newUploadExcludeMatcher(localDir, v.remoteSettings.UploadExclude(), excludePatterns)
+	if err != nil {
+		return "", err
+	}
+	manifest, err := newLocalUploadManifest(localDir, exclude)
+	if err != nil {
+		return "", err
+	}
+	contentHash := manifest.Hash()
+	remoteDir := filepath.Join(v.uploadDirectory(), contentHash)
+
+	verified, err := v.verifyRemoteUpload(contentHash)
+	if err != nil {
+		return "", err
+	}
+	if verified {
+		v.o.Output(fmt.Sprintf("The directory '%s' has been already uploaded to '%s'.", localDir, remoteDir))
+		return remoteDir, nil
+	}
+
+	latestPath := filepath.Join(v.uploadDirectory(),
+		fmt.Sprintf("%s.%s", v.getMD5Hash(localDir), uploadLatestExtension))
+	previousHash, err := v.runCommandOutput(fmt.Sprintf("cat \"%s\" 2>/dev/null || true", latestPath))
+	if err != nil {
+		return "", err
+	}
+	previousHash = strings.TrimSpace(previousHash)
+
+	incremental := false
+	if previousHash != "" && previousHash != contentHash {
+		if incremental, err = v.verifyRemoteUpload(previousHash); err != nil {
+			return "", err
+		}
+	}
+
+	if incremental {
+		previousManifestText, err := v.runCommandOutput(fmt.Sprintf("cat \"%s\"", v.remoteManifestPath(previousHash)))
+		if err != nil {
+			return "", err
+		}
+		changed, removed := manifest.Changes(parseUploadManifest(previousManifestText))
+		previousDir := filepath.Join(v.uploadDirectory(), previousHash)
+		v.o.Output(fmt.Sprintf("Updating the previous upload '%s' of '%s' in '%s': %d changed, %d removed file(s)...",
+			previousDir, localDir, remoteDir, len(changed), len(removed)))
+		if err := v.fs.Remove(remoteDir); err != nil {
+			return "", err
+		}
+		if err := v.runCommandNoSudo(fmt.Sprintf("cp -R \"%s\" \"%s\"", previousDir, remoteDir)); err != nil {
+			return "", err
+		}
+		for _, relPath := range removed {
+			if err := v.fs.Remove(path.Join(remoteDir, relPath)); err != nil {
+				return "", err
+			}
+		}
+		if err := v.uploadArchive(remoteDir, localDir, changed); err != nil {
+			return "", err
+		}
+	} else {
+		v.o.Output(fmt.Sprintf("Uploading the directory '%s' to '%s'...", localDir, remoteDir))
+		if err := v.fs.Remove(remoteDir); err != nil {
+			return "", err
+		}
+		if err := v.uploadArchive(remoteDir, localDir, manifest.Paths()); err != nil {
+			return "", err
+		}
+	}
+
+	// the manifest is written last, an interrupted upload is never verified:
+	if err := v.comm.Upload(v.remoteManifestPath(contentHash), strings.NewReader(manifest.String())); err != nil {
+		return "", err
+	}
+	if err := v.comm.Upload(latestPath, strings.NewReader(contentHash)); err != nil {
+		return "", err
+	}
+	return remoteDir, nil
+}
+
+func (v *RemoteMode) remoteManifestPath(contentHash string) string {
+	return filepath.Join(v.uploadDirectory(), fmt.Sprintf("%s.%s", contentHash, uploadManifestExtension))
+}
+
+// verifyRemoteUpload checks that the upload with the given content hash exists and matches its manifest.
+func (v *RemoteMode) verifyRemoteUpload(contentHash string) (bool, error) {
+	command := verifyUploadCommand(v.remoteManifestPath(contentHash), filepath.Join(v.uploadDirectory(), contentHash))
+	if err := v.runCommandNoSudo(command); err != nil {
+		if status, ok := remoteExitStatus(err); ok && status == uploadVerifiedExitCode {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// verifyUploadCommand returns a command exiting with uploadVerifiedExitCode when the directory matches the manifest.
+// sha256sum fails on the empty manifest of a directory without files, the existing directory matches it.
+// The permission bits are left out, not every sha256sum skips comment lines.
+func verifyUploadCommand(manifestPath string, dir string) string {
+	return fmt.Sprintf("/bin/sh -c 'if [ -f \"%s\" ] && cd \"%s\" && { [ ! -s \"%s\" ] || grep -v \"^#\" \"%s\" | sha256sum -c --status -; }; then exit %d; fi'",
+		manifestPath,
+		dir,
+		manifestPath,
+		manifestPath,
+		uploadVerifiedExitCode)
+}
+
+// uploadArchive uploads the files of a local directory as a single tar.gz stream
+// and unpacks it into the remote directory once the archive checksum is verified.
+func (v *RemoteMode) uploadArchive(remoteDir string, localDir string, paths []string) error {
+	if len(paths) == 0 {
+		return v.fs.MkdirAll(remoteDir)
+	}
+
+	archive, err := newUploadArchive(localDir, paths)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.path)
+
+	file, err := os.Open(archive.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	remoteArchivePath := fmt.Sprintf("%s.tar.gz", remoteDir)
+	started := time.Now()
+	if err := v.comm.Upload(remoteArchivePath, bufio.NewReader(file)); err != nil {
+		return err
+	}
+	if err := v.runCommandNoSudo(unpackArchiveCommand(archive.digest, remoteArchivePath, remoteDir)); err != nil {
+		return fmt.Errorf("failed verifying and unpacking '%s': %v", remoteArchivePath, err)
+	}
+	v.o.Output(fmt.Sprintf("Uploaded %d file(s) of '%s', %d bytes compressed to %d bytes, in %s.",
+		len(paths),
+		localDir,
+		archive.contentBytes,
+		archive.archiveBytes,
+		time.Since(started).Round(time.Millisecond)))
+	return nil
+}
+
+// unpackArchiveCommand returns a command verifying the archive checksum and unpacking the archive,
+// the archive is removed in any case.
+func unpackArchiveCommand(digest string, archivePath string, dir string) string {
+	return fmt.Sprintf("/bin/sh -c 'echo \"%s  %s\" | sha256sum -c --status && mkdir -p \"%s\" && tar -xzf \"%s\" -C \"%s\"; status=$?; rm -f \"%s\"; exit $status'",
+		digest,
+		archivePath,
+		dir,
+		archivePath,
+		dir,
+		archivePath)
+}
+
+type uploadArchive struct {
+	path         string
+	digest       string
+	contentBytes int64
+	archiveBytes int64
+}
+
+// newUploadArchive writes the given files of a local directory to a temporary tar.gz file.
+func newUploadArchive(localDir string, paths []string) (*uploadArchive, error) {
+	file, err := ioutil.TempFile(os.TempDir(), "tf-ansible-upload")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	archive := &uploadArchive{path: file.Name()}
+	hasher := sha256.New()
+	gzipWriter := gzip.NewWriter(io.MultiWriter(file, hasher))
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	writeEntry := func(relPath string) error {
+		localPath := filepath.Join(localDir, filepath.FromSlash(relPath))
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = relPath
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		source, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		written, err := io.Copy(tarWriter, source)
+		archive.contentBytes = archive.contentBytes + written
+		return err
+	}
+
+	for _, relPath := range paths {
+		if err := writeEntry(relPath); err != nil {
+			os.Remove(file.Name())
+			return nil, err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+	archive.archiveBytes = info.Size()
+	archive.digest = hex.EncodeToString(hasher.Sum(nil))
+	return archive, nil
+}
@@ -0,0 +1,272 @@
+package mode
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTestUploadDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "upload-manifest")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for name, contents := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := ioutil.WriteFile(filePath, []byte(contents), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestUploadManifestContentAddressing(t *testing.T) {
+	files := map[string]string{
+		"playbook.yml":                "- hosts: all\n",
+		"roles/web/tasks/main.yml":    "- debug: msg=web\n",
+		"roles/db/defaults/main.yml":  "port: 5432\n",
+		"group_vars/all/settings.yml": "setting: 1\n",
+	}
+	dir1 := writeTestUploadDir(t, files)
+	defer os.RemoveAll(dir1)
+	dir2 := writeTestUploadDir(t, files)
+	defer os.RemoveAll(dir2)
+
+	manifest1, err := newLocalUploadManifest(dir1, newUploadExcludeMatcherFromPatterns(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	manifest2, err := newLocalUploadManifest(dir2, newUploadExcludeMatcherFromPatterns(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if manifest1.Hash() != manifest2.Hash() {
+		t.Fatal("Expected the same content to have the same hash regardless of the local path")
+	}
+	if parseUploadManifest(manifest1.String()).Hash() != manifest1.Hash() {
+		t.Fatal("Expected the parsed manifest to have the same hash")
+	}
+
+	// change one file, remove one file, add one file:
+	ioutil.WriteFile(filepath.Join(dir2, "playbook.yml"), []byte("- hosts: web\n"), 0644)
+	os.Remove(filepath.Join(dir2, "roles", "db", "defaults", "main.yml"))
+	ioutil.WriteFile(filepath.Join(dir2, "roles", "web", "handlers.yml"), []byte("[]\n"), 0644)
+	manifest2, err = newLocalUploadManifest(dir2, newUploadExcludeMatcherFromPatterns(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if manifest1.Hash() == manifest2.Hash() {
+		t.Fatal("Expected changed content to have a different hash")
+	}
+	changed, removed := manifest2.Changes(parseUploadManifest(manifest1.String()))
+	if !reflect.DeepEqual(changed, []string{"playbook.yml", "roles/web/handlers.yml"}) {
+		t.Fatalf("Unexpected changed files: %v", changed)
+	}
+	if !reflect.DeepEqual(removed, []string{"roles/db/defaults/main.yml"}) {
+		t.Fatalf("Unexpected removed files: %v", removed)
+	}
+}
+
+func TestUploadManifestIncludesFileModes(t *testing.T) {
+	dir := writeTestUploadDir(t, map[string]string{
+		"playbook.yml":       "- hosts: all\n",
+		"files/bootstrap.sh": "#!/bin/sh\n",
+	})
+	defer os.RemoveAll(dir)
+
+	manifest1, err := newLocalUploadManifest(dir, newUploadExcludeMatcherFromPatterns(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// change only the mode of one file:
+	if err := os.Chmod(filepath.Join(dir, "files", "bootstrap.sh"), 0755); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	manifest2, err := newLocalUploadManifest(dir, newUploadExcludeMatcherFromPatterns(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if manifest1.Hash() == manifest2.Hash() {
+		t.Fatal("Expected a changed file mode to change the hash")
+	}
+	if parseUploadManifest(manifest2.String()).Hash() != manifest2.Hash() {
+		t.Fatal("Expected the parsed manifest to keep the file modes")
+	}
+	changed, removed := manifest2.Changes(parseUploadManifest(manifest1.String()))
+	if !reflect.DeepEqual(changed, []string{"files/bootstrap.sh"}) {
+		t.Fatalf("Unexpected changed files: %v", changed)
+	}
+	if len(removed) != 0 {
+		t.Fatalf("Unexpected removed files: %v", removed)
+	}
+}
+
+func TestUploadManifestVerifiesWithSha256sum(t *testing.T) {
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("sha256sum not available")
+	}
+	dir := writeTestUploadDir(t, map[string]string{
+		"playbook.yml":             "- hosts: all\n",
+		"roles/web/tasks/main.yml": "- debug: msg=web\n",
+	})
+	defer os.RemoveAll(dir)
+	manifest, err := newLocalUploadManifest(dir, newUploadExcludeMatcherFromPatterns(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	manifestFile := dir + "." + uploadManifestExtension
+	ioutil.WriteFile(manifestFile, []byte(manifest.String()), 0644)
+	defer os.Remove(manifestFile)
+
+	verify := exec.Command("sha256sum", "-c", "--status", manifestFile)
+	verify.Dir = dir
+	if err := verify.Run(); err != nil {
+		t.Fatalf("Expected the manifest to verify with sha256sum but got: %v", err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "playbook.yml"), []byte("- hosts: stale\n"), 0644)
+	verify = exec.Command("sha256sum", "-c", "--status", manifestFile)
+	verify.Dir = dir
+	if err := verify.Run(); err == nil {
+		t.Fatal("Expected a changed file to fail the verification")
+	}
+}
+
+func TestUploadArchiveUnpacksWithVerification(t *testing.T) {
+	for _, command := range []string{"sha256sum", "tar"} {
+		if _, err := exec.LookPath(command); err != nil {
+			t.Skipf("%s not available", command)
+		}
+	}
+	dir := writeTestUploadDir(t, map[string]string{
+		"playbook.yml":             "- hosts: all\n",
+		"roles/web/tasks/main.yml": "- debug: msg=web\n",
+		"files/run.sh":             "#!/bin/sh\n",
+	})
+	defer os.RemoveAll(dir)
+	os.Chmod(filepath.Join(dir, "files", "run.sh"), 0755)
+
+	manifest, err := newLocalUploadManifest(dir, newUploadExcludeMatcherFromPatterns(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	archive, err := newUploadArchive(dir, manifest.Paths())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.Remove(archive.path)
+	if archive.contentBytes == 0 || archive.archiveBytes == 0 {
+		t.Fatal("Expected byte counts to be reported")
+	}
+
+	target, err := ioutil.TempDir("", "upload-archive-target")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(target)
+
+	// a corrupted archive is not unpacked:
+	command := unpackArchiveCommand(strings.Repeat("0", 64), archive.path, filepath.Join(target, "corrupted"))
+	if err := exec.Command("/bin/sh", "-c", command).Run(); err == nil {
+		t.Fatal("Expected the checksum verification to fail")
+	}
+	if _, err := os.Stat(filepath.Join(target, "corrupted")); err == nil {
+		t.Fatal("Did not expect an unverified archive to be unpacked")
+	}
+
+	archive, err = newUploadArchive(dir, manifest.Paths())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.Remove(archive.path)
+	unpacked := filepath.Join(target, "unpacked")
+	command = unpackArchiveCommand(archive.digest, archive.path, unpacked)
+	if output, err := exec.Command("/bin/sh", "-c", command).CombinedOutput(); err != nil {
+		t.Fatalf("Expected the archive to unpack but got: %v, %s", err, string(output))
+	}
+	unpackedManifest, err := newLocalUploadManifest(unpacked, newUploadExcludeMatcherFromPatterns(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if unpackedManifest.Hash() != manifest.Hash() {
+		t.Fatalf("Expected unpacked contents to match, got:\n%s", unpackedManifest.String())
+	}
+	if info, err := os.Stat(filepath.Join(unpacked, "files", "run.sh")); err != nil || info.Mode()&0100 == 0 {
+		t.Fatal("Expected file modes to be preserved")
+	}
+	if _, err := os.Stat(archive.path); err == nil {
+		t.Fatal("Expected the archive to be removed after unpacking")
+	}
+}
+
+func TestUploadManifestFollowsSymlinks(t *testing.T) {
+	dir := writeTestUploadDir(t, map[string]string{
+		"playbook.yml": "- hosts: all\n",
+	})
+	defer os.RemoveAll(dir)
+	shared := writeTestUploadDir(t, map[string]string{
+		"roles/common/tasks/main.yml": "- debug: msg=common\n",
+		"vars/settings.yml":           "setting: 1\n",
+	})
+	defer os.RemoveAll(shared)
+	os.MkdirAll(filepath.Join(dir, "roles"), 0755)
+	os.Symlink(filepath.Join(shared, "roles", "common"), filepath.Join(dir, "roles", "common"))
+	os.Symlink(filepath.Join(shared, "vars", "settings.yml"), filepath.Join(dir, "settings.yml"))
+
+	manifest, err := newLocalUploadManifest(dir, newUploadExcludeMatcherFromPatterns(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"playbook.yml", "roles/common/tasks/main.yml", "settings.yml"}
+	if !reflect.DeepEqual(manifest.Paths(), expected) {
+		t.Fatalf("Expected the linked files %v but got: %v", expected, manifest.Paths())
+	}
+
+	// a link to a directory containing it would never end:
+	loop := filepath.Join(dir, "roles", "loop")
+	os.Symlink(dir, loop)
+	if _, err := newLocalUploadManifest(dir, newUploadExcludeMatcherFromPatterns(nil)); err == nil || !strings.Contains(err.Error(), "points to a directory containing it") {
+		t.Fatalf("Expected the link loop to fail but got: %v", err)
+	}
+	os.Remove(loop)
+
+	// a broken link fails, unless excluded:
+	os.Symlink(filepath.Join(shared, "missing.yml"), filepath.Join(dir, "missing.yml"))
+	if _, err := newLocalUploadManifest(dir, newUploadExcludeMatcherFromPatterns(nil)); err == nil || !strings.Contains(err.Error(), "is broken") {
+		t.Fatalf("Expected the broken link to fail but got: %v", err)
+	}
+	if _, err := newLocalUploadManifest(dir, newUploadExcludeMatcherFromPatterns([]string{"missing.yml"})); err != nil {
+		t.Fatalf("Expected the excluded broken link to be skipped but got: %v", err)
+	}
+}
+
+func TestUploadManifestVerifiesEmptyDirectory(t *testing.T) {
+	dir := writeTestUploadDir(t, map[string]string{})
+	defer os.RemoveAll(dir)
+	manifest, err := newLocalUploadManifest(dir, newUploadExcludeMatcherFromPatterns(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if manifest.String() != "" {
+		t.Fatalf("Expected an empty manifest but got: %q", manifest.String())
+	}
+	manifestFile := dir + "." + uploadManifestExtension
+	ioutil.WriteFile(manifestFile, []byte(manifest.String()), 0644)
+	defer os.Remove(manifestFile)
+
+	err = exec.Command("/bin/sh", "-c", verifyUploadCommand(manifestFile, dir)).Run()
+	if status, ok := err.(*exec.ExitError); !ok || status.ExitCode() != uploadVerifiedExitCode {
+		t.Fatalf("Expected the empty directory to be verified but got: %v", err)
+	}
+	// the directory is gone:
+	os.RemoveAll(dir)
+	if err := exec.Command("/bin/sh", "-c", verifyUploadCommand(manifestFile, dir)).Run(); err != nil {
+		t.Fatalf("Expected the missing directory not to be verified but got: %v", err)
+	}
+}
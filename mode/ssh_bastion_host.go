@@ -1,7 +1,6 @@
 package mode
 
 import (
-	"fmt"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -61,5 +60,5 @@ func (v *bastionHost) connect() (*ssh.Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	return ssh.Dial("tcp", fmt.Sprintf("%s:%d", v.host(), v.port()), sshConfig)
+	return ssh.Dial("tcp", sshAddress(v.host(), v.port()), sshConfig)
 }
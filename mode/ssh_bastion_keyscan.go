@@ -137,12 +137,7 @@ func (b *bastionKeyScan) scan() (string, error) {
 	timeSpentMs := 0
 	intervalMs := 5000
 
-	sshKeyScanCommand := fmt.Sprintf("ssh_keyscan_result=$(ssh-keyscan -T %d -p %d %s 2>/dev/null | grep %s) && echo -e \"${ssh_keyscan_result}\" > \"%s\"",
-		b.sshKeyscanTimeout,
-		b.port,
-		b.host,
-		b.host,
-		targetPath)
+	sshKeyScanCommand := keyScanCommand(b.host, b.port, b.sshKeyscanTimeout, targetPath)
 
 	// do not rely just on the ssh-keyscan -T;
 	// it may take time until the instance starts replying to ssh requests
@@ -160,8 +155,8 @@ func (b *bastionKeyScan) scan() (string, error) {
 		if timeSpentMs > timeoutMs {
 			return "", b.makeError(
 				fmt.Sprintf(
-					"failed receive target ssh key for %s:%d within time specified period of %d seconds.",
-					b.host, b.port, b.sshKeyscanTimeout), nil)
+					"failed receive target ssh key for %s within time specified period of %d seconds.",
+					sshAddress(b.host, b.port), b.sshKeyscanTimeout), nil)
 		}
 	}
 
@@ -182,3 +177,15 @@ func (b *bastionKeyScan) scan() (string, error) {
 func (b *bastionKeyScan) quotedSSHKnownFileDir() string {
 	return strings.Replace(homeSSHDirectory, "~/", "$HOME/", 1)
 }
+
+// keyScanCommand returns the command writing the known_hosts lines of the host to the target path.
+// ssh-keyscan takes the raw host and prints known_hosts lines
+// with the host in the [host]:port form for non default ports.
+func keyScanCommand(host string, port int, timeout int, targetPath string) string {
+	return fmt.Sprintf("ssh_keyscan_result=$(ssh-keyscan -T %d -p %d '%s' 2>/dev/null | grep -F '%s') && echo -e \"${ssh_keyscan_result}\" > \"%s\"",
+		timeout,
+		port,
+		host,
+		knownHostsAddress(host, port),
+		targetPath)
+}
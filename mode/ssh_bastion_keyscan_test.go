@@ -0,0 +1,23 @@
+package mode
+
+import (
+	"testing"
+)
+
+func TestBastionKeyScanCommand(t *testing.T) {
+	for _, tc := range []struct {
+		host     string
+		port     int
+		expected string
+	}{
+		{"10.0.0.5", 22, "ssh_keyscan_result=$(ssh-keyscan -T 60 -p 22 '10.0.0.5' 2>/dev/null | grep -F '10.0.0.5') && echo -e \"${ssh_keyscan_result}\" > \"/home/user/.ssh/key\""},
+		{"10.0.0.5", 2222, "ssh_keyscan_result=$(ssh-keyscan -T 60 -p 2222 '10.0.0.5' 2>/dev/null | grep -F '[10.0.0.5]:2222') && echo -e \"${ssh_keyscan_result}\" > \"/home/user/.ssh/key\""},
+		{"2001:db8::10", 22, "ssh_keyscan_result=$(ssh-keyscan -T 60 -p 22 '2001:db8::10' 2>/dev/null | grep -F '2001:db8::10') && echo -e \"${ssh_keyscan_result}\" > \"/home/user/.ssh/key\""},
+		// ssh-keyscan takes the raw IPv6 address, known_hosts has it in brackets with the port:
+		{"2001:db8::10", 2222, "ssh_keyscan_result=$(ssh-keyscan -T 60 -p 2222 '2001:db8::10' 2>/dev/null | grep -F '[2001:db8::10]:2222') && echo -e \"${ssh_keyscan_result}\" > \"/home/user/.ssh/key\""},
+	} {
+		if command := keyScanCommand(tc.host, tc.port, 60, "/home/user/.ssh/key"); command != tc.expected {
+			t.Fatalf("Expected the keyscan command for %s port %d:\n%s\nbut got:\n%s", tc.host, tc.port, tc.expected, command)
+		}
+	}
+}
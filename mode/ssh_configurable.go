@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"strconv"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -28,12 +29,17 @@ type sshConfigurator struct {
 }
 
 func (c *sshConfigurator) sshConfig() (*ssh.ClientConfig, error) {
+	// an unparsable key or an unreachable agent gives no auth method:
 	authMethods := make([]ssh.AuthMethod, 0)
 	if c.provider.pemFile() != "" {
-		authMethods = append(authMethods, c.publicKeyFile())
+		if authMethod := c.publicKeyFile(); authMethod != nil {
+			authMethods = append(authMethods, authMethod)
+		}
 	}
 	if c.provider.agent() {
-		authMethods = append(authMethods, c.sshAgent())
+		if authMethod := c.sshAgent(); authMethod != nil {
+			authMethods = append(authMethods, authMethod)
+		}
 	}
 
 	hostKeyCallback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
@@ -58,7 +64,9 @@ func (c *sshConfigurator) sshConfig() (*ssh.ClientConfig, error) {
 		// we mark this as a CA as well, but the host key fallback will still
 		// use it as a direct match if the remote host doesn't return a
 		// certificate.
-		if _, err := tf.WriteString(fmt.Sprintf("@cert-authority %s %s\n", c.provider.host(), c.provider.hostKey())); err != nil {
+		if _, err := tf.WriteString(fmt.Sprintf("@cert-authority %s %s\n",
+			knownhosts.Normalize(sshAddress(c.provider.host(), c.provider.port())),
+			c.provider.hostKey())); err != nil {
 			return nil, fmt.Errorf("failed to write temp known_hosts file: %s", err)
 		}
 		tf.Sync()
@@ -96,3 +104,17 @@ func (c *sshConfigurator) publicKeyFile() ssh.AuthMethod {
 	}
 	return ssh.PublicKeys(key)
 }
+
+// sshAddress returns a host:port address to dial, IPv6 hosts are bracketed.
+func sshAddress(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// knownHostsAddress returns the host the way OpenSSH looks it up in known_hosts files:
+// the raw host for the default port, [host]:port otherwise.
+func knownHostsAddress(host string, port int) string {
+	if port == 0 || port == DefaultPort {
+		return host
+	}
+	return fmt.Sprintf("[%s]:%d", host, port)
+}
@@ -1,6 +1,7 @@
 package mode
 
 import (
+	"os"
 	"testing"
 	"time"
 
@@ -47,3 +48,52 @@ func TestSSHConfigurable(t *testing.T) {
 		t.Fatal("Expected SSH config but received an error", err)
 	}
 }
+
+func TestSSHAddresses(t *testing.T) {
+	cases := []struct {
+		host       string
+		port       int
+		address    string
+		knownHosts string
+	}{
+		{"10.0.0.1", 22, "10.0.0.1:22", "10.0.0.1"},
+		{"10.0.0.1", 2022, "10.0.0.1:2022", "[10.0.0.1]:2022"},
+		{"2001:db8::1", 22, "[2001:db8::1]:22", "2001:db8::1"},
+		{"2001:db8::1", 2022, "[2001:db8::1]:2022", "[2001:db8::1]:2022"},
+	}
+	for _, c := range cases {
+		if address := sshAddress(c.host, c.port); address != c.address {
+			t.Fatalf("Expected address %s but got %s", c.address, address)
+		}
+		if knownHosts := knownHostsAddress(c.host, c.port); knownHosts != c.knownHosts {
+			t.Fatalf("Expected known hosts address %s but got %s", c.knownHosts, knownHosts)
+		}
+	}
+}
+
+type testingInvalidKeySSHConfigurable struct {
+	*testingSSHConfigurable
+}
+
+func (p *testingInvalidKeySSHConfigurable) pemFile() string {
+	return "not a private key"
+}
+
+func TestSSHConfigurableSkipsUnavailableAuthMethods(t *testing.T) {
+	sshAuthSock := os.Getenv("SSH_AUTH_SOCK")
+	os.Unsetenv("SSH_AUTH_SOCK")
+	defer os.Setenv("SSH_AUTH_SOCK", sshAuthSock)
+
+	configurator := sshConfigurator{
+		provider: &testingInvalidKeySSHConfigurable{
+			testingSSHConfigurable: &testingSSHConfigurable{hostKeyVaule: test.TestSSHHostKeyPublic},
+		},
+	}
+	sshConfig, err := configurator.sshConfig()
+	if err != nil {
+		t.Fatal("Expected SSH config but received an error", err)
+	}
+	if len(sshConfig.Auth) != 0 {
+		t.Fatalf("Expected no auth methods without a valid key and an agent but got: %v", sshConfig.Auth)
+	}
+}
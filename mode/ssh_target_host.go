@@ -1,9 +1,7 @@
 package mode
 
 import (
-	"fmt"
 	"time"
-	
 
 	"golang.org/x/crypto/ssh"
 )
@@ -67,10 +65,55 @@ func (v *targetHost) fetchHostKey() error {
 	if err != nil {
 		return err
 	}
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", v.host(), v.port()), sshConfig)
+	client, err := ssh.Dial("tcp", sshAddress(v.host(), v.port()), sshConfig)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 	return returnError
 }
+
+// connect connects to the target, through the bastion if in use. The returned clients have to be closed
+// in reverse order, the target client is the last one.
+func (v *targetHost) connect() ([]*ssh.Client, error) {
+	configurator := &sshConfigurator{
+		provider: v,
+	}
+	sshConfig, err := configurator.sshConfig()
+	if err != nil {
+		return nil, err
+	}
+	if v.connInfo.Password != "" {
+		sshConfig.Auth = append(sshConfig.Auth, ssh.Password(v.connInfo.Password))
+	}
+	return v.dial(sshAddress(v.host(), v.port()), sshConfig)
+}
+
+// dial connects to the address with the SSH configuration, through the bastion of the connection if in use.
+// The returned clients have to be closed in reverse order, the client of the address is the last one.
+func (v *targetHost) dial(address string, sshConfig *ssh.ClientConfig) ([]*ssh.Client, error) {
+	bastion := newBastionHostFromConnectionInfo(v.connInfo)
+	if !bastion.inUse() {
+		client, err := ssh.Dial("tcp", address, sshConfig)
+		if err != nil {
+			return nil, err
+		}
+		return []*ssh.Client{client}, nil
+	}
+	bastionClient, err := bastion.connect()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := bastionClient.Dial("tcp", address)
+	if err != nil {
+		bastionClient.Close()
+		return nil, err
+	}
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, address, sshConfig)
+	if err != nil {
+		conn.Close()
+		bastionClient.Close()
+		return nil, err
+	}
+	return []*ssh.Client{bastionClient, ssh.NewClient(clientConn, chans, reqs)}, nil
+}
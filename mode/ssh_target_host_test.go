@@ -1,6 +1,7 @@
 package mode
 
 import (
+	"net"
 	"testing"
 
 	"github.com/hashicorp/terraform/terraform"
@@ -66,3 +67,52 @@ func TestTargetHostConfiguration(t *testing.T) {
 	}
 
 }
+
+func TestTargetHostIPv6(t *testing.T) {
+	if listener, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skip("IPv6 loopback not available", err)
+	} else {
+		listener.Close()
+	}
+
+	instanceState := &terraform.InstanceState{
+		Ephemeral: terraform.EphemeralState{
+			ConnInfo: map[string]string{
+				"type":        "ssh",
+				"user":        "test-username",
+				"private_key": test.TestSSHUserKeyPrivate,
+				"host":        "::1",
+				"port":        "0",
+				"agent":       "false",
+				"timeout":     "10m",
+			},
+		},
+	}
+
+	output := new(terraform.MockUIOutput)
+	sshServer := test.GetConfiguredAndRunningSSHServer(t, "ssh-target-host-ipv6", false, instanceState, output)
+	defer sshServer.Stop()
+
+	connInfo, err := parseConnectionInfo(instanceState)
+	if err != nil {
+		t.Fatal("Expected connection info but got an error", err)
+	}
+	th := newTargetHostFromConnectionInfo(connInfo)
+	if th.host() != "::1" {
+		t.Fatal("Expected raw IPv6 host but got", th.host())
+	}
+
+	// no host key given, the key is received from the server:
+	if err := th.fetchHostKey(); err != nil {
+		t.Fatal("Expected fetchHostKey to succeed.", err)
+	}
+	if th.hostKey() == "" {
+		t.Fatal("Expected the host key to be received")
+	}
+
+	// the received host key verifies the server:
+	connInfo.HostKey = test.TestSSHHostKeyPublic
+	if err := th.fetchHostKey(); err != nil {
+		t.Fatal("Expected host key verification to succeed.", err)
+	}
+}
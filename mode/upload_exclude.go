@@ -0,0 +1,147 @@
+package mode
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// uploadIgnoreFile is read from the root of every uploaded directory.
+const uploadIgnoreFile = ".ansibleignore"
+
+type uploadExcludeRule struct {
+	expression *regexp.Regexp
+	negate     bool
+	dirOnly    bool
+}
+
+// uploadExcludeMatcher decides which files are not uploaded, using the gitignore pattern syntax.
+// Like in gitignore, the last matching pattern decides.
+type uploadExcludeMatcher struct {
+	rules []*uploadExcludeRule
+}
+
+// newUploadExcludeMatcher returns a matcher for the patterns of the remote settings, followed by
+// the patterns of the .ansibleignore file of the directory, followed by the patterns of the play.
+func newUploadExcludeMatcher(dir string, remotePatterns []string, playPatterns []string) (*uploadExcludeMatcher, error) {
+	patterns := make([]string, 0)
+	patterns = append(patterns, remotePatterns...)
+	file, err := os.Open(filepath.Join(dir, uploadIgnoreFile))
+	if err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			patterns = append(patterns, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	patterns = append(patterns, playPatterns...)
+	return newUploadExcludeMatcherFromPatterns(patterns), nil
+}
+
+func newUploadExcludeMatcherFromPatterns(patterns []string) *uploadExcludeMatcher {
+	matcher := &uploadExcludeMatcher{rules: make([]*uploadExcludeRule, 0)}
+	for _, pattern := range patterns {
+		if rule := parseUploadExcludePattern(pattern); rule != nil {
+			matcher.rules = append(matcher.rules, rule)
+		}
+	}
+	return matcher
+}
+
+// Excluded returns true when the slash separated path, relative to the uploaded directory, is not uploaded.
+func (m *uploadExcludeMatcher) Excluded(relPath string, isDir bool) bool {
+	excluded := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.expression.MatchString(relPath) {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
+
+// parseUploadExcludePattern converts a gitignore pattern to a rule, returns nil for blank lines and comments.
+func parseUploadExcludePattern(pattern string) *uploadExcludeRule {
+	pattern = strings.TrimRight(strings.TrimRight(pattern, "\r"), " ")
+	if strings.HasSuffix(pattern, "\\") {
+		// escaped trailing space:
+		pattern = pattern + " "
+	}
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil
+	}
+	rule := &uploadExcludeRule{}
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return nil
+	}
+	// a pattern with a slash at the beginning or in the middle is relative to the uploaded directory,
+	// otherwise it matches at any level:
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	expression := "^"
+	if !anchored {
+		expression = expression + "(?:.*/)?"
+	}
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '*' && i+1 < len(pattern) && pattern[i+1] == '*' && (i == 0 || pattern[i-1] == '/') &&
+			(i+2 == len(pattern) || pattern[i+2] == '/'):
+			if i+2 == len(pattern) {
+				// trailing /**, everything inside:
+				expression = expression + ".*"
+			} else {
+				// leading **/ or /**/, zero or more directories:
+				expression = expression + "(?:.*/)?"
+				i++
+			}
+			i++
+		case c == '*':
+			expression = expression + "[^/]*"
+		case c == '?':
+			expression = expression + "[^/]"
+		case c == '[':
+			end := strings.Index(pattern[i+1:], "]")
+			if end < 0 {
+				expression = expression + regexp.QuoteMeta(string(c))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expression = expression + "[" + strings.Replace(class, "\\", "\\\\", -1) + "]"
+			i = i + 1 + end
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			expression = expression + regexp.QuoteMeta(string(pattern[i]))
+		default:
+			expression = expression + regexp.QuoteMeta(string(c))
+		}
+	}
+	compiled, err := regexp.Compile(expression + "$")
+	if err != nil {
+		// an invalid character class, match the pattern literally:
+		compiled = regexp.MustCompile("^" + regexp.QuoteMeta(pattern) + "$")
+	}
+	rule.expression = compiled
+	return rule
+}
@@ -0,0 +1,81 @@
+package mode
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestUploadExcludePatterns(t *testing.T) {
+	cases := []struct {
+		patterns []string
+		path     string
+		isDir    bool
+		excluded bool
+	}{
+		{[]string{".git"}, ".git", true, true},
+		{[]string{".git"}, "roles/web/.git", true, true},
+		{[]string{"*.pyc"}, "library/module.pyc", false, true},
+		{[]string{"*.pyc"}, "library/module.py", false, false},
+		{[]string{"/venv"}, "venv", true, true},
+		{[]string{"/venv"}, "roles/venv", true, false},
+		{[]string{"build/"}, "build", true, true},
+		{[]string{"build/"}, "build", false, false},
+		{[]string{"docs/*.md"}, "docs/index.md", false, true},
+		{[]string{"docs/*.md"}, "docs/api/index.md", false, false},
+		{[]string{"**/.molecule"}, "roles/web/.molecule", true, true},
+		{[]string{"roles/**/cache"}, "roles/cache", true, true},
+		{[]string{"roles/**/cache"}, "roles/web/files/cache", true, true},
+		{[]string{"files/**"}, "files/a/b.txt", false, true},
+		{[]string{"files/**"}, "files", true, false},
+		{[]string{"file?.txt"}, "file1.txt", false, true},
+		{[]string{"file[0-9].txt"}, "file1.txt", false, true},
+		{[]string{"file[!0-9].txt"}, "file1.txt", false, false},
+		{[]string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{[]string{"!keep.log", "*.log"}, "keep.log", false, true},
+		{[]string{"# comment", "", "\\#hash"}, "#hash", false, true},
+		{[]string{"# comment"}, "# comment", false, false},
+		{[]string{"trailing   "}, "trailing", false, true},
+		{[]string{"space\\ "}, "space ", false, true},
+	}
+	for _, c := range cases {
+		matcher := newUploadExcludeMatcherFromPatterns(c.patterns)
+		if matcher.Excluded(c.path, c.isDir) != c.excluded {
+			t.Errorf("Expected patterns %q for '%s' (directory: %v) to return excluded: %v", c.patterns, c.path, c.isDir, c.excluded)
+		}
+	}
+}
+
+func TestUploadManifestExcludes(t *testing.T) {
+	dir := writeTestUploadDir(t, map[string]string{
+		".ansibleignore":             "# local state\n.terraform/\n*.retry\n!important.retry\n",
+		".git/HEAD":                  "ref: refs/heads/master\n",
+		".terraform/plugins/p":       "plugin\n",
+		"venv/bin/python":            "binary\n",
+		"playbook.yml":               "- hosts: all\n",
+		"playbook.retry":             "host\n",
+		"important.retry":            "host\n",
+		"molecule/default/cache.yml": "cache\n",
+		"roles/web/tasks/main.yml":   "- debug: msg=web\n",
+	})
+	defer os.RemoveAll(dir)
+
+	matcher, err := newUploadExcludeMatcher(dir, []string{".git/", "venv/"}, []string{"molecule/", "!venv/"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	manifest, err := newLocalUploadManifest(dir, matcher)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{
+		".ansibleignore",
+		"important.retry",
+		"playbook.yml",
+		"roles/web/tasks/main.yml",
+		"venv/bin/python",
+	}
+	if !reflect.DeepEqual(manifest.Paths(), expected) {
+		t.Fatalf("Expected uploaded files %v but got %v", expected, manifest.Paths())
+	}
+}
@@ -52,6 +52,9 @@ func validateFn(c *terraform.ResourceConfig) (ws []string, es []error) {
 	// Workaround to enable backward compatibility
 	var computedTfVersion terraformVersion
 
+	// with remote.pull, the playbook file_path is the path in the repository:
+	_, pullInUse := c.Get("remote.0.pull.0.repository")
+
 	if plays, hasPlays := c.Get("plays"); hasPlays {
 
 		var sanitizedPlays []interface{}
@@ -70,7 +73,7 @@ func validateFn(c *terraform.ResourceConfig) (ws []string, es []error) {
 			return ws, es // return early
 		}
 
-		for _, rawVPlay := range sanitizedPlays {
+		for i, rawVPlay := range sanitizedPlays {
 			vPlay := rawVPlay.(map[string]interface{})
 
 			currentErrorCount := len(es)
@@ -102,6 +105,18 @@ func validateFn(c *terraform.ResourceConfig) (ws []string, es []error) {
 						return ws, es // return early
 					}
 
+					filePathKey := fmt.Sprintf("plays.%d.playbook.0.file_path", i)
+					if filePath, hasFilePath := c.Get(filePathKey); hasFilePath && !pullInUse && !c.IsComputed(filePathKey) {
+						vws, ves := types.VfPath(filePath, "file_path")
+
+						for _, w := range vws {
+							ws = append(ws, w)
+						}
+						for _, e := range ves {
+							es = append(es, e)
+						}
+					}
+
 					if hasRolesPath {
 						for _, singlePath := range rolesPath {
 							vws, ves := types.VfPathDirectory(singlePath, "roles_path")
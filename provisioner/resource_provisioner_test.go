@@ -217,6 +217,41 @@ func TestRequirePlaybookFilePath(t *testing.T) {
 	}
 }
 
+func TestPlaybookFilePathMustExistWithoutPull(t *testing.T) {
+	plays := []interface{}{
+		map[string]interface{}{
+			"playbook": []interface{}{
+				map[string]interface{}{
+					"file_path": "site/does-not-exist.yml",
+				},
+			},
+		},
+	}
+	_, errs := Provisioner().Validate(testConfig(t, map[string]interface{}{
+		"plays": plays,
+	}))
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got: %v", errs)
+	}
+
+	// with remote.pull, the playbook is in the repository:
+	_, errs = Provisioner().Validate(testConfig(t, map[string]interface{}{
+		"plays": plays,
+		"remote": []interface{}{
+			map[string]interface{}{
+				"pull": []interface{}{
+					map[string]interface{}{
+						"repository": "git@example.com:ops/playbooks.git",
+					},
+				},
+			},
+		},
+	}))
+	if len(errs) > 0 {
+		t.Fatalf("Errors: %v", errs)
+	}
+}
+
 func TestRequireModuleName(t *testing.T) {
 	c := testConfig(t, map[string]interface{}{
 		"plays": []interface{}{
@@ -396,6 +431,64 @@ func TestConfigProvisionerParserDecoder(t *testing.T) {
 	}
 }
 
+func TestConfigProvisionerDecodesPlayAnsibleSSHSettings(t *testing.T) {
+	c := map[string]interface{}{
+		"plays": []interface{}{
+			map[string]interface{}{
+				"playbook": []interface{}{
+					map[string]interface{}{
+						"file_path": playbookFile,
+					},
+				},
+				"ansible_ssh_settings": []interface{}{
+					map[string]interface{}{
+						"connect_timeout_seconds": 300,
+						"connection_attempts":     60,
+						"pipelining":              false,
+					},
+				},
+			},
+		},
+		"ansible_ssh_settings": []interface{}{
+			map[string]interface{}{
+				"connect_timeout_seconds": 5,
+				"pipelining":              true,
+				"server_alive_interval":   10,
+			},
+		},
+	}
+
+	warn, errs := Provisioner().Validate(testConfig(t, c))
+	if len(warn) > 0 {
+		t.Fatalf("Warnings: %+v", warn)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("Errors: %+v", errs)
+	}
+
+	p, err := decodeConfig(
+		schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, c),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error while decoding the configuration: %+v", err)
+	}
+
+	merged := p.ansibleSSHSettings.Merge(p.plays[0].AnsibleSSHSettings())
+	if merged.ConnectTimeoutSeconds() != 300 || merged.ConnectAttempts() != 60 {
+		t.Fatalf("Expected play level SSH settings to take precedence but got %d / %d",
+			merged.ConnectTimeoutSeconds(), merged.ConnectAttempts())
+	}
+	if merged.Pipelining() {
+		t.Fatal("Expected the play to turn pipelining off")
+	}
+	if merged.ServerAliveInterval() != 10 {
+		t.Fatalf("Expected provisioner level server alive interval 10 but got %d", merged.ServerAliveInterval())
+	}
+	if p.ansibleSSHSettings.ConnectTimeoutSeconds() != 5 {
+		t.Fatalf("Expected provisioner level connect timeout 5 but got %d", p.ansibleSSHSettings.ConnectTimeoutSeconds())
+	}
+}
+
 func testConfig(t *testing.T, c map[string]interface{}) *terraform.ResourceConfig {
 	//r, err := configs.NewRawConfig(c)
 	//if err != nil {
@@ -1,8 +1,8 @@
 package test
 
 import (
-	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -211,7 +211,7 @@ func GetConfiguredAndRunningSSHServer(t *testing.T, serverID string, localMode b
 	sshConfig := &TestingSSHServerConfig{
 		ServerID:           serverID,
 		HostKey:            TestSSHHostKeyPrivate,
-		HostPort:           fmt.Sprintf("%s:%s", instanceState.Ephemeral.ConnInfo["host"], instanceState.Ephemeral.ConnInfo["port"]),
+		HostPort:           net.JoinHostPort(instanceState.Ephemeral.ConnInfo["host"], instanceState.Ephemeral.ConnInfo["port"]),
 		AuthenticatedUsers: []*TestingSSHUser{authUser},
 		Listeners:          5,
 		Output:             output,
@@ -268,6 +268,28 @@ func GetNewRemoteSettings(t *testing.T, raw map[string]interface{}) *types.Remot
 	return types.NewRemoteSettingsFromMapInterface(raw, true)
 }
 
+// GetNewRemoteSettingsWithDefaults returns *types.RemoteSettings from the default raw remote settings
+// with the given attributes replaced or added.
+func GetNewRemoteSettingsWithDefaults(t *testing.T, overrides map[string]interface{}) *types.RemoteSettings {
+	raw := map[string]interface{}{
+		"use_sudo":                   true,
+		"skip_install":               false,
+		"skip_cleanup":               false,
+		"install_version":            "",
+		"install_method":             "pip",
+		"install_package":            "ansible",
+		"offline_bundle":             "",
+		"local_installer_path":       "",
+		"remote_installer_directory": "/tmp",
+		"bootstrap_directory":        "/tmp",
+		"upload_exclude":             []interface{}{},
+	}
+	for key, value := range overrides {
+		raw[key] = value
+	}
+	return GetNewRemoteSettings(t, raw)
+}
+
 // GetNewSSHInstanceState returns a new instance of *teraform.InstanceState for a given SSH username.
 func GetNewSSHInstanceState(t *testing.T, sshUsername string) *terraform.InstanceState {
 	return &terraform.InstanceState{
@@ -377,3 +399,14 @@ func WriteTempPlaybook(t *testing.T, dirpath string) string {
 
 	return playbookFilePath
 }
+
+// GetNewAnsibleSSHSettings returns *types.AnsibleSSHSettings from a raw map, unset values take schema defaults.
+func GetNewAnsibleSSHSettings(t *testing.T, raw map[string]interface{}) *types.AnsibleSSHSettings {
+	settingsSchemas := map[string]*schema.Schema{
+		"ansible_ssh_settings": types.NewAnsibleSSHSettingsSchema(),
+	}
+	data := schema.TestResourceDataRaw(t, settingsSchemas, map[string]interface{}{
+		"ansible_ssh_settings": []interface{}{raw},
+	})
+	return types.NewAnsibleSSHSettingsFromInterface(data.GetOk("ansible_ssh_settings"))
+}
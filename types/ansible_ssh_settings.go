@@ -1,6 +1,7 @@
 package types
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 
@@ -16,8 +17,19 @@ type AnsibleSSHSettings struct {
 	insecureBastionNoStrictHostKeyChecking bool
 	userKnownHostsFile                     string
 	bastionUserKnownHostsFile              string
-	overrideStrictHostKeyChecking          bool
-
+	controlPersistSeconds                  int
+	controlPathDir                         string
+	pipelining                             bool
+	serverAliveInterval                    int
+	serverAliveCountMax                    int
+	sshConfigFile                          string
+	generateSSHConfig                      bool
+	hostKeys                               []string
+	readinessTimeoutSeconds                int
+	readinessMaxBackoffSeconds             int
+	waitForCloudInit                       bool
+	// play level overrides only, attributes explicitly set on the play:
+	setAttributes map[string]bool
 }
 
 const (
@@ -25,6 +37,11 @@ const (
 	ansibleSSHDefaultConnectTimeoutSeconds = 10
 	ansibleSSHDefaultConnectAttempts       = 10
 	ansibleSSHDefaultSSHKeyscanSeconds     = 60
+	ansibleSSHDefaultControlPersistSeconds = 0 // multiplexing managed by Ansible
+	ansibleSSHDefaultServerAliveInterval   = 0 // not applied
+	ansibleSSHDefaultServerAliveCountMax   = 0 // not applied
+	ansibleSSHDefaultReadinessTimeout      = 0 // readiness not checked
+	ansibleSSHDefaultReadinessMaxBackoff   = 30
 	// attribute names:
 	ansibleSSHAttributeConnectTimeoutSeconds                  = "connect_timeout_seconds"
 	ansibleSSHAttributeConnectAttempts                        = "connection_attempts"
@@ -33,10 +50,26 @@ const (
 	ansibleSSHAttributeInsecureBastionNoStrictHostKeyChecking = "insecure_bastion_no_strict_host_key_checking"
 	ansibleSSHAttributeUserKnownHostsFile                     = "user_known_hosts_file"
 	ansibleSSHAttributeBastionUserKnownHostsFile              = "bastion_user_known_hosts_file"
+	ansibleSSHAttributeControlPersist                         = "control_persist"
+	ansibleSSHAttributeControlPathDir                         = "control_path_dir"
+	ansibleSSHAttributePipelining                             = "pipelining"
+	ansibleSSHAttributeServerAliveInterval                    = "server_alive_interval"
+	ansibleSSHAttributeServerAliveCountMax                    = "server_alive_count_max"
+	ansibleSSHAttributeSSHConfigFile                          = "ssh_config_file"
+	ansibleSSHAttributeGenerateSSHConfig                      = "generate_ssh_config"
+	ansibleSSHAttributeHostKeys                               = "host_keys"
+	ansibleSSHAttributeReadinessTimeout                       = "readiness_timeout"
+	ansibleSSHAttributeReadinessMaxBackoff                    = "readiness_max_backoff"
+	ansibleSSHAttributeWaitForCloudInit                       = "wait_for_cloud_init"
 	// environment variable names:
 	ansibleSSHEnvConnectTimeoutSeconds = "TF_PROVISIONER_ANSIBLE_SSH_CONNECT_TIMEOUT_SECONDS"
 	ansibleSSHEnvConnectAttempts       = "TF_PROVISIONER_ANSIBLE_SSH_CONNECTION_ATTEMPTS"
 	ansibleSSHEnvSSHKeyscanSeconds     = "TF_PROVISIONER_SSH_KEYSCAN_TIMEOUT_SECONDS"
+	ansibleSSHEnvControlPersistSeconds = "TF_PROVISIONER_ANSIBLE_SSH_CONTROL_PERSIST_SECONDS"
+	ansibleSSHEnvServerAliveInterval   = "TF_PROVISIONER_ANSIBLE_SSH_SERVER_ALIVE_INTERVAL"
+	ansibleSSHEnvServerAliveCountMax   = "TF_PROVISIONER_ANSIBLE_SSH_SERVER_ALIVE_COUNT_MAX"
+	ansibleSSHEnvReadinessTimeout      = "TF_PROVISIONER_ANSIBLE_SSH_READINESS_TIMEOUT_SECONDS"
+	ansibleSSHEnvReadinessMaxBackoff   = "TF_PROVISIONER_ANSIBLE_SSH_READINESS_MAX_BACKOFF_SECONDS"
 )
 
 // NewAnsibleSSHSettingsSchema returns a new AnsibleSSHSettings schema.
@@ -47,34 +80,19 @@ func NewAnsibleSSHSettingsSchema() *schema.Schema {
 		Elem: &schema.Resource{
 			Schema: map[string]*schema.Schema{
 				ansibleSSHAttributeConnectTimeoutSeconds: &schema.Schema{
-					Type:     schema.TypeInt,
-					Optional: true,
-					DefaultFunc: func() (interface{}, error) {
-						if val, err := strconv.Atoi(os.Getenv(ansibleSSHEnvConnectTimeoutSeconds)); err == nil {
-							return val, nil
-						}
-						return ansibleSSHDefaultConnectTimeoutSeconds, nil
-					},
+					Type:        schema.TypeInt,
+					Optional:    true,
+					DefaultFunc: envIntDefaultFunc(ansibleSSHEnvConnectTimeoutSeconds, ansibleSSHDefaultConnectTimeoutSeconds),
 				},
 				ansibleSSHAttributeConnectAttempts: &schema.Schema{
-					Type:     schema.TypeInt,
-					Optional: true,
-					DefaultFunc: func() (interface{}, error) {
-						if val, err := strconv.Atoi(os.Getenv(ansibleSSHEnvConnectAttempts)); err == nil {
-							return val, nil
-						}
-						return ansibleSSHDefaultConnectAttempts, nil
-					},
+					Type:        schema.TypeInt,
+					Optional:    true,
+					DefaultFunc: envIntDefaultFunc(ansibleSSHEnvConnectAttempts, ansibleSSHDefaultConnectAttempts),
 				},
 				ansibleSSHAttributeSSHKeyscanSeconds: &schema.Schema{
-					Type:     schema.TypeInt,
-					Optional: true,
-					DefaultFunc: func() (interface{}, error) {
-						if val, err := strconv.Atoi(os.Getenv(ansibleSSHEnvSSHKeyscanSeconds)); err == nil {
-							return val, nil
-						}
-						return ansibleSSHDefaultSSHKeyscanSeconds, nil
-					},
+					Type:        schema.TypeInt,
+					Optional:    true,
+					DefaultFunc: envIntDefaultFunc(ansibleSSHEnvSSHKeyscanSeconds, ansibleSSHDefaultSSHKeyscanSeconds),
 				},
 				ansibleSSHAttributeInsecureNoStrictHostKeyChecking: &schema.Schema{
 					Type:     schema.TypeBool,
@@ -96,6 +114,62 @@ func NewAnsibleSSHSettingsSchema() *schema.Schema {
 					Optional: true,
 					Default:  "",
 				},
+				ansibleSSHAttributeControlPersist: &schema.Schema{
+					Type:        schema.TypeInt,
+					Optional:    true,
+					DefaultFunc: envIntDefaultFunc(ansibleSSHEnvControlPersistSeconds, ansibleSSHDefaultControlPersistSeconds),
+				},
+				ansibleSSHAttributeControlPathDir: &schema.Schema{
+					Type:     schema.TypeString,
+					Optional: true,
+					Default:  "",
+				},
+				ansibleSSHAttributePipelining: &schema.Schema{
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  false,
+				},
+				ansibleSSHAttributeServerAliveInterval: &schema.Schema{
+					Type:        schema.TypeInt,
+					Optional:    true,
+					DefaultFunc: envIntDefaultFunc(ansibleSSHEnvServerAliveInterval, ansibleSSHDefaultServerAliveInterval),
+				},
+				ansibleSSHAttributeServerAliveCountMax: &schema.Schema{
+					Type:        schema.TypeInt,
+					Optional:    true,
+					DefaultFunc: envIntDefaultFunc(ansibleSSHEnvServerAliveCountMax, ansibleSSHDefaultServerAliveCountMax),
+				},
+				ansibleSSHAttributeSSHConfigFile: &schema.Schema{
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      "",
+					ValidateFunc: VfPath,
+				},
+				ansibleSSHAttributeGenerateSSHConfig: &schema.Schema{
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  false,
+				},
+				ansibleSSHAttributeHostKeys: &schema.Schema{
+					Type:     schema.TypeList,
+					Elem:     &schema.Schema{Type: schema.TypeString},
+					Optional: true,
+				},
+				ansibleSSHAttributeReadinessTimeout: &schema.Schema{
+					Type:        schema.TypeInt,
+					Optional:    true,
+					DefaultFunc: envIntDefaultFunc(ansibleSSHEnvReadinessTimeout, ansibleSSHDefaultReadinessTimeout),
+				},
+				ansibleSSHAttributeReadinessMaxBackoff: &schema.Schema{
+					Type:        schema.TypeInt,
+					Optional:    true,
+					DefaultFunc: envIntDefaultFunc(ansibleSSHEnvReadinessMaxBackoff, ansibleSSHDefaultReadinessMaxBackoff),
+				},
+				ansibleSSHAttributeWaitForCloudInit: &schema.Schema{
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  false,
+				},
 			},
 		},
 	}
@@ -104,9 +178,14 @@ func NewAnsibleSSHSettingsSchema() *schema.Schema {
 // NewAnsibleSSHSettingsFromInterface reads AnsibleSSHSettings configuration from Terraform schema.
 func NewAnsibleSSHSettingsFromInterface(i interface{}, ok bool) *AnsibleSSHSettings {
 	v := &AnsibleSSHSettings{
-		connectTimeoutSeconds: ansibleSSHDefaultConnectTimeoutSeconds,
-		connectAttempts:       ansibleSSHDefaultConnectAttempts,
-		sshKeyscanSeconds:     ansibleSSHDefaultSSHKeyscanSeconds,
+		connectTimeoutSeconds:      envIntOrDefault(ansibleSSHEnvConnectTimeoutSeconds, ansibleSSHDefaultConnectTimeoutSeconds),
+		connectAttempts:            envIntOrDefault(ansibleSSHEnvConnectAttempts, ansibleSSHDefaultConnectAttempts),
+		sshKeyscanSeconds:          envIntOrDefault(ansibleSSHEnvSSHKeyscanSeconds, ansibleSSHDefaultSSHKeyscanSeconds),
+		controlPersistSeconds:      envIntOrDefault(ansibleSSHEnvControlPersistSeconds, ansibleSSHDefaultControlPersistSeconds),
+		serverAliveInterval:        envIntOrDefault(ansibleSSHEnvServerAliveInterval, ansibleSSHDefaultServerAliveInterval),
+		serverAliveCountMax:        envIntOrDefault(ansibleSSHEnvServerAliveCountMax, ansibleSSHDefaultServerAliveCountMax),
+		readinessTimeoutSeconds:    envIntOrDefault(ansibleSSHEnvReadinessTimeout, ansibleSSHDefaultReadinessTimeout),
+		readinessMaxBackoffSeconds: envIntOrDefault(ansibleSSHEnvReadinessMaxBackoff, ansibleSSHDefaultReadinessMaxBackoff),
 	}
 	if ok {
 		vals := mapFromTypeSetList(i.(*schema.Set).List())
@@ -117,10 +196,119 @@ func NewAnsibleSSHSettingsFromInterface(i interface{}, ok bool) *AnsibleSSHSetti
 		v.insecureBastionNoStrictHostKeyChecking = vals[ansibleSSHAttributeInsecureBastionNoStrictHostKeyChecking].(bool)
 		v.userKnownHostsFile = vals[ansibleSSHAttributeUserKnownHostsFile].(string)
 		v.bastionUserKnownHostsFile = vals[ansibleSSHAttributeBastionUserKnownHostsFile].(string)
+		v.controlPersistSeconds = vals[ansibleSSHAttributeControlPersist].(int)
+		v.controlPathDir = vals[ansibleSSHAttributeControlPathDir].(string)
+		v.pipelining = vals[ansibleSSHAttributePipelining].(bool)
+		v.serverAliveInterval = vals[ansibleSSHAttributeServerAliveInterval].(int)
+		v.serverAliveCountMax = vals[ansibleSSHAttributeServerAliveCountMax].(int)
+		v.sshConfigFile = vals[ansibleSSHAttributeSSHConfigFile].(string)
+		v.generateSSHConfig = vals[ansibleSSHAttributeGenerateSSHConfig].(bool)
+		v.hostKeys = listOfInterfaceToListOfString(vals[ansibleSSHAttributeHostKeys])
+		v.readinessTimeoutSeconds = vals[ansibleSSHAttributeReadinessTimeout].(int)
+		v.readinessMaxBackoffSeconds = vals[ansibleSSHAttributeReadinessMaxBackoff].(int)
+		v.waitForCloudInit = vals[ansibleSSHAttributeWaitForCloudInit].(bool)
+	}
+	return v
+}
+
+// NewPlayAnsibleSSHSettingsSchema returns a new AnsibleSSHSettings schema used by a play.
+// Play level attributes have no defaults, unset attributes are taken from the provisioner level settings.
+// Boolean and number attributes are strings at the play level, an empty string tells an unset attribute
+// apart from false and 0, Terraform converts booleans and numbers given in the configuration.
+func NewPlayAnsibleSSHSettingsSchema() *schema.Schema {
+	s := NewAnsibleSSHSettingsSchema()
+	// host keys are collected and readiness is checked once per provisioner run:
+	for _, attribute := range []string{
+		ansibleSSHAttributeInsecureNoStrictHostKeyChecking,
+		ansibleSSHAttributeUserKnownHostsFile,
+		ansibleSSHAttributeSSHKeyscanSeconds,
+		ansibleSSHAttributeHostKeys,
+		ansibleSSHAttributeReadinessTimeout,
+		ansibleSSHAttributeReadinessMaxBackoff,
+		ansibleSSHAttributeWaitForCloudInit} {
+		delete(s.Elem.(*schema.Resource).Schema, attribute)
+	}
+	for _, attribute := range s.Elem.(*schema.Resource).Schema {
+		attribute.Default = nil
+		attribute.DefaultFunc = nil
+		switch attribute.Type {
+		case schema.TypeBool:
+			attribute.Type = schema.TypeString
+			attribute.ValidateFunc = vfBoolString
+		case schema.TypeInt:
+			attribute.Type = schema.TypeString
+			attribute.ValidateFunc = vfIntString
+		}
+	}
+	return s
+}
+
+// NewPlayAnsibleSSHSettingsFromInterface reads play level AnsibleSSHSettings overrides from Terraform schema.
+// Attributes with a non-empty value are considered set, including these set to false or 0.
+func NewPlayAnsibleSSHSettingsFromInterface(i interface{}) *AnsibleSSHSettings {
+	vals := mapFromTypeSetList(i.(*schema.Set).List())
+	v := &AnsibleSSHSettings{
+		setAttributes: make(map[string]bool),
+	}
+	for name, val := range vals {
+		if sval, ok := val.(string); ok && sval != "" {
+			v.setAttributes[name] = true
+		}
 	}
+	v.connectTimeoutSeconds = intFromString(vals[ansibleSSHAttributeConnectTimeoutSeconds])
+	v.connectAttempts = intFromString(vals[ansibleSSHAttributeConnectAttempts])
+	v.insecureBastionNoStrictHostKeyChecking = boolFromString(vals[ansibleSSHAttributeInsecureBastionNoStrictHostKeyChecking])
+	v.bastionUserKnownHostsFile, _ = vals[ansibleSSHAttributeBastionUserKnownHostsFile].(string)
+	v.controlPersistSeconds = intFromString(vals[ansibleSSHAttributeControlPersist])
+	v.controlPathDir, _ = vals[ansibleSSHAttributeControlPathDir].(string)
+	v.pipelining = boolFromString(vals[ansibleSSHAttributePipelining])
+	v.serverAliveInterval = intFromString(vals[ansibleSSHAttributeServerAliveInterval])
+	v.serverAliveCountMax = intFromString(vals[ansibleSSHAttributeServerAliveCountMax])
+	v.sshConfigFile, _ = vals[ansibleSSHAttributeSSHConfigFile].(string)
+	v.generateSSHConfig = boolFromString(vals[ansibleSSHAttributeGenerateSSHConfig])
 	return v
 }
 
+// Merge returns a copy of the settings with the attributes set in the play level overrides applied.
+// Settings are returned unchanged when overrides are nil.
+func (v *AnsibleSSHSettings) Merge(overrides *AnsibleSSHSettings) *AnsibleSSHSettings {
+	merged := *v
+	merged.setAttributes = nil
+	if overrides == nil {
+		return &merged
+	}
+	for name, isSet := range overrides.setAttributes {
+		if !isSet {
+			continue
+		}
+		switch name {
+		case ansibleSSHAttributeConnectTimeoutSeconds:
+			merged.connectTimeoutSeconds = overrides.connectTimeoutSeconds
+		case ansibleSSHAttributeConnectAttempts:
+			merged.connectAttempts = overrides.connectAttempts
+		case ansibleSSHAttributeInsecureBastionNoStrictHostKeyChecking:
+			merged.insecureBastionNoStrictHostKeyChecking = overrides.insecureBastionNoStrictHostKeyChecking
+		case ansibleSSHAttributeBastionUserKnownHostsFile:
+			merged.bastionUserKnownHostsFile = overrides.bastionUserKnownHostsFile
+		case ansibleSSHAttributeControlPersist:
+			merged.controlPersistSeconds = overrides.controlPersistSeconds
+		case ansibleSSHAttributeControlPathDir:
+			merged.controlPathDir = overrides.controlPathDir
+		case ansibleSSHAttributePipelining:
+			merged.pipelining = overrides.pipelining
+		case ansibleSSHAttributeServerAliveInterval:
+			merged.serverAliveInterval = overrides.serverAliveInterval
+		case ansibleSSHAttributeServerAliveCountMax:
+			merged.serverAliveCountMax = overrides.serverAliveCountMax
+		case ansibleSSHAttributeSSHConfigFile:
+			merged.sshConfigFile = overrides.sshConfigFile
+		case ansibleSSHAttributeGenerateSSHConfig:
+			merged.generateSSHConfig = overrides.generateSSHConfig
+		}
+	}
+	return &merged
+}
+
 // ConnectTimeoutSeconds reutrn Ansible process SSH connection timeout.
 func (v *AnsibleSSHSettings) ConnectTimeoutSeconds() int {
 	return v.connectTimeoutSeconds
@@ -136,21 +324,21 @@ func (v *AnsibleSSHSettings) SSHKeyscanSeconds() int {
 	return v.sshKeyscanSeconds
 }
 
-// InsecureNoStrictHostKeyChecking if true, SSH to the target host uses -o StrictHostKeyChecking=no.
-func (v *AnsibleSSHSettings) InsecureNoStrictHostKeyChecking() bool {
-	if v.overrideStrictHostKeyChecking || v.insecureNoStrictHostKeyChecking {
-		return true
-	} else {
-		return false
+// HostKeyCollectionSeconds returns how long host key collection retries until failing.
+// Host keys are collected before the readiness check, a longer readiness timeout extends the collection
+// so that hosts still booting are not given up on before they are checked for readiness.
+func (v *AnsibleSSHSettings) HostKeyCollectionSeconds() int {
+	if v.readinessTimeoutSeconds > v.sshKeyscanSeconds {
+		return v.readinessTimeoutSeconds
 	}
+	return v.sshKeyscanSeconds
 }
 
-// SetOverrideStrictHostKeyChecking is used by the provisioner when attached to a null_resource
-func (v *AnsibleSSHSettings) SetOverrideStrictHostKeyChecking() {
-	v.overrideStrictHostKeyChecking  = true
+// InsecureNoStrictHostKeyChecking if true, SSH to the target host uses -o StrictHostKeyChecking=no.
+func (v *AnsibleSSHSettings) InsecureNoStrictHostKeyChecking() bool {
+	return v.insecureNoStrictHostKeyChecking
 }
 
-
 // InsecureBastionNoStrictHostKeyChecking if true, SSH to the bastion host uses -o StrictHostKeyChecking=no.
 func (v *AnsibleSSHSettings) InsecureBastionNoStrictHostKeyChecking() bool {
 	return v.insecureBastionNoStrictHostKeyChecking
@@ -165,3 +353,88 @@ func (v *AnsibleSSHSettings) UserKnownHostsFile() string {
 func (v *AnsibleSSHSettings) BastionUserKnownHostsFile() string {
 	return v.bastionUserKnownHostsFile
 }
+
+// ControlPersistSeconds returns SSH ControlPersist value, 0 leaves connection multiplexing to Ansible defaults.
+func (v *AnsibleSSHSettings) ControlPersistSeconds() int {
+	return v.controlPersistSeconds
+}
+
+// ControlPathDir returns a directory under which the provisioner creates a private, per run ControlPath directory.
+// Empty string means the system temp directory.
+func (v *AnsibleSSHSettings) ControlPathDir() string {
+	if v.controlPathDir == "" {
+		return os.TempDir()
+	}
+	return v.controlPathDir
+}
+
+// Pipelining if true, Ansible pipelining is enabled.
+func (v *AnsibleSSHSettings) Pipelining() bool {
+	return v.pipelining
+}
+
+// ServerAliveInterval returns SSH ServerAliveInterval, 0 means not applied.
+func (v *AnsibleSSHSettings) ServerAliveInterval() int {
+	return v.serverAliveInterval
+}
+
+// ServerAliveCountMax returns SSH ServerAliveCountMax, 0 means not applied.
+func (v *AnsibleSSHSettings) ServerAliveCountMax() int {
+	return v.serverAliveCountMax
+}
+
+// SSHConfigFile returns a path to the user provided ssh_config file, passed to Ansible with -F.
+func (v *AnsibleSSHSettings) SSHConfigFile() string {
+	return v.sshConfigFile
+}
+
+// GenerateSSHConfig if true, the provisioner generates an ssh_config file for each play
+// and Ansible is pointed at it instead of receiving inline SSH arguments.
+func (v *AnsibleSSHSettings) GenerateSSHConfig() bool {
+	return v.generateSSHConfig
+}
+
+// HostKeys returns pinned known_hosts lines, hosts covered by these are not collected.
+func (v *AnsibleSSHSettings) HostKeys() []string {
+	return v.hostKeys
+}
+
+// ReadinessTimeoutSeconds returns how long to wait for every host to accept SSH connections
+// before the first play runs, 0 disables the readiness check.
+func (v *AnsibleSSHSettings) ReadinessTimeoutSeconds() int {
+	return v.readinessTimeoutSeconds
+}
+
+// ReadinessMaxBackoffSeconds returns the maximum delay between readiness attempts.
+func (v *AnsibleSSHSettings) ReadinessMaxBackoffSeconds() int {
+	return v.readinessMaxBackoffSeconds
+}
+
+// WaitForCloudInit if true, the readiness check waits for cloud-init to finish on every host.
+func (v *AnsibleSSHSettings) WaitForCloudInit() bool {
+	return v.waitForCloudInit
+}
+
+func (v *AnsibleSSHSettings) serverAliveOptions() []string {
+	options := make([]string, 0)
+	if v.serverAliveInterval > 0 {
+		options = append(options, fmt.Sprintf("-o ServerAliveInterval=%d", v.serverAliveInterval))
+	}
+	if v.serverAliveCountMax > 0 {
+		options = append(options, fmt.Sprintf("-o ServerAliveCountMax=%d", v.serverAliveCountMax))
+	}
+	return options
+}
+
+func envIntOrDefault(name string, defaultValue int) int {
+	if val, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return val
+	}
+	return defaultValue
+}
+
+func envIntDefaultFunc(name string, defaultValue int) schema.SchemaDefaultFunc {
+	return func() (interface{}, error) {
+		return envIntOrDefault(name, defaultValue), nil
+	}
+}
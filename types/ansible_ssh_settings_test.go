@@ -0,0 +1,171 @@
+package types_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+	"github.com/radekg/terraform-provisioner-ansible/v2/types"
+)
+
+func getTestPlayAnsibleSSHSettings(t *testing.T, raw map[string]interface{}) *schema.Set {
+	data := schema.TestResourceDataRaw(t, map[string]*schema.Schema{
+		"ansible_ssh_settings": types.NewPlayAnsibleSSHSettingsSchema(),
+	}, map[string]interface{}{
+		"ansible_ssh_settings": []interface{}{raw},
+	})
+	return data.Get("ansible_ssh_settings").(*schema.Set)
+}
+
+func getTestPlayWithAnsibleSSHSettings(t *testing.T, raw map[string]interface{}) *types.Play {
+	return getTestModulePlay(t, map[string]interface{}{
+		"ansible_ssh_settings": getTestPlayAnsibleSSHSettings(t, raw),
+	})
+}
+
+func TestPlayAnsibleSSHSettingsMergeFieldByField(t *testing.T) {
+	global := test.GetNewAnsibleSSHSettings(t, map[string]interface{}{
+		"connect_timeout_seconds": 5,
+		"connection_attempts":     3,
+		"server_alive_interval":   10,
+	})
+	play := getTestPlayWithAnsibleSSHSettings(t, map[string]interface{}{
+		"connect_timeout_seconds": 120,
+		"pipelining":              true,
+	})
+
+	merged := global.Merge(play.AnsibleSSHSettings())
+	if merged.ConnectTimeoutSeconds() != 120 {
+		t.Fatalf("Expected play level connect timeout 120 but got %d", merged.ConnectTimeoutSeconds())
+	}
+	if !merged.Pipelining() {
+		t.Fatal("Expected play level pipelining to be enabled")
+	}
+	if merged.ConnectAttempts() != 3 {
+		t.Fatalf("Expected provisioner level connection attempts 3 but got %d", merged.ConnectAttempts())
+	}
+	if merged.ServerAliveInterval() != 10 {
+		t.Fatalf("Expected provisioner level server alive interval 10 but got %d", merged.ServerAliveInterval())
+	}
+	if global.ConnectTimeoutSeconds() != 5 || global.Pipelining() {
+		t.Fatal("Expected provisioner level settings to remain unchanged after merge")
+	}
+}
+
+func TestPlayAnsibleSSHSettingsMergeWithEnvironmentDefaults(t *testing.T) {
+	os.Setenv("TF_PROVISIONER_ANSIBLE_SSH_CONNECTION_ATTEMPTS", "42")
+	defer os.Unsetenv("TF_PROVISIONER_ANSIBLE_SSH_CONNECTION_ATTEMPTS")
+
+	global := types.NewAnsibleSSHSettingsFromInterface(nil, false)
+	play := getTestPlayWithAnsibleSSHSettings(t, map[string]interface{}{
+		"connect_timeout_seconds": 60,
+	})
+
+	merged := global.Merge(play.AnsibleSSHSettings())
+	if merged.ConnectAttempts() != 42 {
+		t.Fatalf("Expected connection attempts from the environment but got %d", merged.ConnectAttempts())
+	}
+	if merged.ConnectTimeoutSeconds() != 60 {
+		t.Fatalf("Expected play level connect timeout 60 but got %d", merged.ConnectTimeoutSeconds())
+	}
+}
+
+func TestPlayWithoutAnsibleSSHSettingsMergesToGlobal(t *testing.T) {
+	global := test.GetNewAnsibleSSHSettings(t, map[string]interface{}{
+		"connect_timeout_seconds": 7,
+	})
+	play := getTestModulePlay(t, map[string]interface{}{})
+	if play.AnsibleSSHSettings() != nil {
+		t.Fatal("Expected no play level settings")
+	}
+	if merged := global.Merge(play.AnsibleSSHSettings()); merged.ConnectTimeoutSeconds() != 7 {
+		t.Fatalf("Expected provisioner level connect timeout 7 but got %d", merged.ConnectTimeoutSeconds())
+	}
+}
+
+func TestPlayAnsibleSSHSettingsMergeZeroValues(t *testing.T) {
+	global := test.GetNewAnsibleSSHSettings(t, map[string]interface{}{
+		"pipelining":             true,
+		"control_persist":        60,
+		"server_alive_interval":  10,
+		"server_alive_count_max": 3,
+	})
+	play := getTestPlayWithAnsibleSSHSettings(t, map[string]interface{}{
+		"pipelining":             false,
+		"control_persist":        0,
+		"server_alive_count_max": 0,
+	})
+
+	merged := global.Merge(play.AnsibleSSHSettings())
+	if merged.Pipelining() {
+		t.Fatal("Expected the play to turn pipelining off")
+	}
+	if merged.ServerAliveCountMax() != 0 {
+		t.Fatalf("Expected the play to disable server alive count max but got %d", merged.ServerAliveCountMax())
+	}
+	if merged.ControlPersistSeconds() != 0 {
+		t.Fatalf("Expected the play to disable control persist but got %d", merged.ControlPersistSeconds())
+	}
+	if merged.ServerAliveInterval() != 10 {
+		t.Fatalf("Expected provisioner level server alive interval 10 but got %d", merged.ServerAliveInterval())
+	}
+}
+
+func TestPlayAnsibleSSHSettingsValidatesStrings(t *testing.T) {
+	attributes := types.NewPlayAnsibleSSHSettingsSchema().Elem.(*schema.Resource).Schema
+	for _, tc := range []struct {
+		attribute string
+		value     string
+		valid     bool
+	}{
+		{"pipelining", "false", true},
+		{"pipelining", "", true},
+		{"pipelining", "sometimes", false},
+		{"control_persist", "0", true},
+		{"control_persist", "60s", false},
+	} {
+		_, errs := attributes[tc.attribute].ValidateFunc(tc.value, tc.attribute)
+		if (len(errs) == 0) != tc.valid {
+			t.Fatalf("Unexpected validation of %s = '%s': %v", tc.attribute, tc.value, errs)
+		}
+	}
+}
+
+func TestPlayAnsibleSSHSettingsWithoutHostKeyVerification(t *testing.T) {
+	attributes := types.NewPlayAnsibleSSHSettingsSchema().Elem.(*schema.Resource).Schema
+	// host keys are collected once per provisioner run with the provisioner level settings:
+	for _, attribute := range []string{"insecure_no_strict_host_key_checking", "user_known_hosts_file", "ssh_keyscan_timeout", "host_keys"} {
+		if _, ok := attributes[attribute]; ok {
+			t.Fatalf("Did not expect '%s' at the play level", attribute)
+		}
+	}
+}
+
+func TestAnsibleSSHSettingsReadinessMaxBackoffFromEnvironment(t *testing.T) {
+	os.Setenv("TF_PROVISIONER_ANSIBLE_SSH_READINESS_MAX_BACKOFF_SECONDS", "5")
+	defer os.Unsetenv("TF_PROVISIONER_ANSIBLE_SSH_READINESS_MAX_BACKOFF_SECONDS")
+
+	if settings := types.NewAnsibleSSHSettingsFromInterface(nil, false); settings.ReadinessMaxBackoffSeconds() != 5 {
+		t.Fatalf("Expected readiness max backoff from the environment but got %d", settings.ReadinessMaxBackoffSeconds())
+	}
+	if settings := test.GetNewAnsibleSSHSettings(t, map[string]interface{}{}); settings.ReadinessMaxBackoffSeconds() != 5 {
+		t.Fatalf("Expected readiness max backoff from the environment but got %d", settings.ReadinessMaxBackoffSeconds())
+	}
+}
+
+func TestAnsibleSSHSettingsHostKeyCollectionCoversReadiness(t *testing.T) {
+	settings := test.GetNewAnsibleSSHSettings(t, map[string]interface{}{
+		"ssh_keyscan_timeout": 60,
+	})
+	if settings.HostKeyCollectionSeconds() != 60 {
+		t.Fatalf("Expected host keys to be collected for 60 seconds but got %d", settings.HostKeyCollectionSeconds())
+	}
+	settings = test.GetNewAnsibleSSHSettings(t, map[string]interface{}{
+		"ssh_keyscan_timeout": 60,
+		"readiness_timeout":   600,
+	})
+	if settings.HostKeyCollectionSeconds() != 600 {
+		t.Fatalf("Expected host keys to be collected for the readiness timeout but got %d", settings.HostKeyCollectionSeconds())
+	}
+}
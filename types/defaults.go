@@ -81,7 +81,7 @@ func NewDefaultsSchema() *schema.Schema {
 				defaultsAttributeInventoryFile: &schema.Schema{
 					Type:         schema.TypeString,
 					Optional:     true,
-					ValidateFunc: vfPath,
+					ValidateFunc: VfPath,
 				},
 				defaultsAttributeLimit: &schema.Schema{
 					Type:     schema.TypeString,
@@ -96,7 +96,7 @@ func NewDefaultsSchema() *schema.Schema {
 				defaultsAttributeVaultPasswordFile: &schema.Schema{
 					Type:          schema.TypeString,
 					Optional:      true,
-					ValidateFunc:  vfPath,
+					ValidateFunc:  VfPath,
 					ConflictsWith: []string{"defaults.vault_id"},
 				},
 			},
@@ -66,12 +66,12 @@ func NewGalaxyInstallSchema() *schema.Schema {
 				ansibleGalaxyAttributeRoleFile: &schema.Schema{
 					Type:         schema.TypeString,
 					Required:     true,
-					ValidateFunc: vfPath,
+					ValidateFunc: VfPath,
 				},
 				ansibleGalaxyAttributeRolesPath: &schema.Schema{
 					Type:         schema.TypeString,
 					Optional:     true,
-					ValidateFunc: vfPath,
+					ValidateFunc: VfPath,
 				},
 				ansibleGalaxyAttributeServer: &schema.Schema{
 					Type:     schema.TypeString,
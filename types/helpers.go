@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	homedir "github.com/mitchellh/go-homedir"
@@ -20,6 +21,27 @@ var (
 		"ksu":    true,
 		"runas":  true,
 	}
+	installMethods = map[string]bool{
+		"pip":     true,
+		"venv":    true,
+		"pipx":    true,
+		"package": true,
+	}
+	cleanupPolicies = map[string]bool{
+		RemoteCleanupAlways:    true,
+		RemoteCleanupOnSuccess: true,
+		RemoteCleanupNever:     true,
+	}
+	remoteBecomeCommands = map[string]bool{
+		RemoteBecomeSudo:  true,
+		RemoteBecomeDoas:  true,
+		RemoteBecomeSu:    true,
+		RemoteBecomePbrun: true,
+	}
+	installPackages = map[string]bool{
+		"ansible":      true,
+		"ansible-core": true,
+	}
 )
 
 // HasMoreThanOneTrue checks if a list of booleans contains more than one true value.
@@ -44,7 +66,63 @@ func vfBecomeMethod(val interface{}, key string) (warns []string, errs []error)
 	return
 }
 
-func vfPath(val interface{}, key string) (warns []string, errs []error) {
+func vfInstallMethod(val interface{}, key string) (warns []string, errs []error) {
+	v := val.(string)
+	if !installMethods[v] {
+		errs = append(errs, fmt.Errorf("%s is not a valid install_method", v))
+	}
+	return
+}
+
+func vfCleanupPolicy(val interface{}, key string) (warns []string, errs []error) {
+	v := val.(string)
+	if !cleanupPolicies[v] {
+		errs = append(errs, fmt.Errorf("%s is not a valid cleanup policy", v))
+	}
+	return
+}
+
+func vfRemoteBecomeCommand(val interface{}, key string) (warns []string, errs []error) {
+	v := val.(string)
+	if !remoteBecomeCommands[v] {
+		errs = append(errs, fmt.Errorf("%s is not a valid become_command", v))
+	}
+	return
+}
+
+func vfInstallPackage(val interface{}, key string) (warns []string, errs []error) {
+	v := val.(string)
+	if !installPackages[v] {
+		errs = append(errs, fmt.Errorf("%s is not a valid install_package", v))
+	}
+	return
+}
+
+func vfFileMode(val interface{}, key string) (warns []string, errs []error) {
+	if _, err := parseFileMode(val.(string)); err != nil {
+		errs = append(errs, err)
+	}
+	return
+}
+
+func vfBoolString(val interface{}, key string) (warns []string, errs []error) {
+	v := val.(string)
+	if _, err := strconv.ParseBool(v); v != "" && err != nil {
+		errs = append(errs, fmt.Errorf("%s is not a valid boolean for %s", v, key))
+	}
+	return
+}
+
+func vfIntString(val interface{}, key string) (warns []string, errs []error) {
+	v := val.(string)
+	if _, err := strconv.Atoi(v); v != "" && err != nil {
+		errs = append(errs, fmt.Errorf("%s is not a valid number for %s", v, key))
+	}
+	return
+}
+
+// VfPath validates existence of a path.
+func VfPath(val interface{}, key string) (warns []string, errs []error) {
 	v := val.(string)
 	if strings.Index(v, "${path.module}") > -1 {
 		warns = append(warns, fmt.Sprintf("Unable to determine the existence of '%s', most likely because of https://github.com/hashicorp/terraform/issues/17439. If the file does not exist, you'll experience a failure at runtime.", v))
@@ -58,6 +136,21 @@ func vfPath(val interface{}, key string) (warns []string, errs []error) {
 	return
 }
 
+func vfOfflineBundle(val interface{}, key string) (warns []string, errs []error) {
+	warns, errs = VfPath(val, key)
+	if len(errs) > 0 || len(warns) > 0 {
+		return
+	}
+	v := val.(string)
+	if _, err := ResolveDirectory(v); err == nil {
+		return
+	}
+	if !strings.HasSuffix(v, ".tar.gz") && !strings.HasSuffix(v, ".tgz") {
+		errs = append(errs, fmt.Errorf("offline bundle '%s' must be a directory or a .tar.gz / .tgz file", v))
+	}
+	return
+}
+
 // VfPathDirectory validates existence of a path and that the path is a directory.
 func VfPathDirectory(val interface{}, key string) (warns []string, errs []error) {
 	v := val.(string)
@@ -95,6 +188,18 @@ func mapFromTypeSetList(i []interface{}) map[string]interface{} {
 	return make(map[string]interface{})
 }
 
+// boolFromString parses a validated play level boolean attribute, an unset attribute is false.
+func boolFromString(v interface{}) bool {
+	val, _ := strconv.ParseBool(fmt.Sprintf("%v", v))
+	return val
+}
+
+// intFromString parses a validated play level number attribute, an unset attribute is 0.
+func intFromString(v interface{}) int {
+	val, _ := strconv.Atoi(fmt.Sprintf("%v", v))
+	return val
+}
+
 func listOfInterfaceToListOfString(v interface{}) []string {
 	var result []string
 	switch v := v.(type) {
@@ -12,4 +12,6 @@ type LocalModeAnsibleArgs struct {
 	BastionHost           string
 	BastionPort           int
 	BastionPemFile        string
+	ControlPathDir        string
+	SSHConfigFile         string
 }
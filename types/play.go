@@ -34,6 +34,7 @@ type Play struct {
 	overrideInventoryFile     string
 	overrideVaultID           []string
 	overrideVaultPasswordFile string
+	ansibleSSHSettings        *AnsibleSSHSettings
 }
 
 const (
@@ -46,25 +47,31 @@ const (
 	ansibleEnvVarRolesPath        = "ANSIBLE_ROLES_PATH"
 	ansibleEnvVarDefaultRolesPath = "DEFAULT_ROLES_PATH"
 	ansibleEnvVarRemoteTmp        = "ANSIBLE_REMOTE_TMP"
+	ansibleEnvVarSSHArgs          = "ANSIBLE_SSH_ARGS"
+	ansibleEnvVarControlPathDir   = "ANSIBLE_SSH_CONTROL_PATH_DIR"
+	ansibleEnvVarPipelining       = "ANSIBLE_PIPELINING"
+	ansibleEnvVarCollectionsPaths = "ANSIBLE_COLLECTIONS_PATHS"
+	ansibleEnvVarLibrary          = "ANSIBLE_LIBRARY"
 	// attribute names:
-	playAttributeEnabled           = "enabled"
-	playAttributePlaybook          = "playbook"
-	playAttributeModule            = "module"
-	playAttributeGalaxyInstall     = "galaxy_install"
-	playAttributeHosts             = "hosts"
-	playAttributeGroups            = "groups"
-	playAttributeBecome            = "become"
-	playAttributeBecomeMethod      = "become_method"
-	playAttributeBecomeUser        = "become_user"
-	playAttributeDiff              = "diff"
-	playAttributeCheck             = "check"
-	playAttributeExtraVars         = "extra_vars"
-	playAttributeForks             = "forks"
-	playAttributeInventoryFile     = "inventory_file"
-	playAttributeLimit             = "limit"
-	playAttributeVaultID           = "vault_id"
-	playAttributeVaultPasswordFile = "vault_password_file"
-	playAttributeVerbose           = "verbose"
+	playAttributeEnabled            = "enabled"
+	playAttributePlaybook           = "playbook"
+	playAttributeModule             = "module"
+	playAttributeGalaxyInstall      = "galaxy_install"
+	playAttributeHosts              = "hosts"
+	playAttributeGroups             = "groups"
+	playAttributeBecome             = "become"
+	playAttributeBecomeMethod       = "become_method"
+	playAttributeBecomeUser         = "become_user"
+	playAttributeDiff               = "diff"
+	playAttributeCheck              = "check"
+	playAttributeExtraVars          = "extra_vars"
+	playAttributeForks              = "forks"
+	playAttributeInventoryFile      = "inventory_file"
+	playAttributeLimit              = "limit"
+	playAttributeVaultID            = "vault_id"
+	playAttributeVaultPasswordFile  = "vault_password_file"
+	playAttributeVerbose            = "verbose"
+	playAttributeAnsibleSSHSettings = "ansible_ssh_settings"
 )
 
 // NewPlaySchema returns a new play schema.
@@ -129,7 +136,7 @@ func NewPlaySchema() *schema.Schema {
 				playAttributeInventoryFile: &schema.Schema{
 					Type:         schema.TypeString,
 					Optional:     true,
-					ValidateFunc: vfPath,
+					ValidateFunc: VfPath,
 				},
 				playAttributeLimit: &schema.Schema{
 					Type:     schema.TypeString,
@@ -144,13 +151,14 @@ func NewPlaySchema() *schema.Schema {
 				playAttributeVaultPasswordFile: &schema.Schema{
 					Type:          schema.TypeString,
 					Optional:      true,
-					ValidateFunc:  vfPath,
+					ValidateFunc:  VfPath,
 					ConflictsWith: []string{"plays.vault_id"},
 				},
 				playAttributeVerbose: &schema.Schema{
 					Type:     schema.TypeBool,
 					Optional: true,
 				},
+				playAttributeAnsibleSSHSettings: NewPlayAnsibleSSHSettingsSchema(),
 			},
 		},
 	}
@@ -197,6 +205,11 @@ func NewPlayFromMapInterface(vals map[string]interface{}, defaults *Defaults) *P
 	if val, ok := vals[playAttributeGroups]; ok {
 		v.groups = listOfInterfaceToListOfString(val.([]interface{}))
 	}
+	if val, ok := vals[playAttributeAnsibleSSHSettings]; ok {
+		if set, ok := val.(*schema.Set); ok && set.Len() > 0 {
+			v.ansibleSSHSettings = NewPlayAnsibleSSHSettingsFromInterface(set)
+		}
+	}
 
 	return v
 }
@@ -351,6 +364,12 @@ func (v *Play) Verbose() bool {
 	return v.verbose
 }
 
+// AnsibleSSHSettings returns play level Ansible SSH settings overrides, nil when not given.
+// Use AnsibleSSHSettings.Merge to apply them on top of the provisioner level settings.
+func (v *Play) AnsibleSSHSettings() *AnsibleSSHSettings {
+	return v.ansibleSSHSettings
+}
+
 // SetOverrideInventoryFile is used by the provisioner in the following cases:
 // - remote provisioner not given an inventory_file, a generated temporary file used
 // - local mode always writes a temporary inventory file, such file has to be removed after provisioning
@@ -382,6 +401,59 @@ func (v *Play) defaultRolePaths() []string {
 	return []string{}
 }
 
+// ansiblePluginEnvVars maps the subdirectories of plugin_paths to the Ansible environment variables.
+var ansiblePluginEnvVars = []struct {
+	subdirectory string
+	envVar       string
+}{
+	{"action_plugins", "ANSIBLE_ACTION_PLUGINS"},
+	{"cache_plugins", "ANSIBLE_CACHE_PLUGINS"},
+	{"callback_plugins", "ANSIBLE_CALLBACK_PLUGINS"},
+	{"connection_plugins", "ANSIBLE_CONNECTION_PLUGINS"},
+	{"filter_plugins", "ANSIBLE_FILTER_PLUGINS"},
+	{"inventory_plugins", "ANSIBLE_INVENTORY_PLUGINS"},
+	{"lookup_plugins", "ANSIBLE_LOOKUP_PLUGINS"},
+	{"module_utils", "ANSIBLE_MODULE_UTILS"},
+	{"strategy_plugins", "ANSIBLE_STRATEGY_PLUGINS"},
+	{"test_plugins", "ANSIBLE_TEST_PLUGINS"},
+	{"vars_plugins", "ANSIBLE_VARS_PLUGINS"},
+}
+
+// appendEnvPaths returns the paths of an environment variable, if set, followed by the given paths.
+func appendEnvPaths(envVar string, paths []string) []string {
+	result := make([]string, 0)
+	if val, ok := os.LookupEnv(envVar); ok && val != "" {
+		result = append(result, strings.Split(val, ":")...)
+	}
+	for _, path := range paths {
+		result = append(result, filepath.Clean(path))
+	}
+	return result
+}
+
+// playbookPathsEnvironment returns the collections, module and plugin environment variables of a playbook.
+func playbookPathsEnvironment(playbook *Playbook) []string {
+	env := make([]string, 0)
+	if len(playbook.CollectionsPath()) > 0 {
+		env = append(env, fmt.Sprintf("%s=%s", ansibleEnvVarCollectionsPaths,
+			strings.Join(appendEnvPaths(ansibleEnvVarCollectionsPaths, playbook.CollectionsPath()), ":")))
+	}
+	if len(playbook.LibraryPath()) > 0 {
+		env = append(env, fmt.Sprintf("%s=%s", ansibleEnvVarLibrary,
+			strings.Join(appendEnvPaths(ansibleEnvVarLibrary, playbook.LibraryPath()), ":")))
+	}
+	if len(playbook.PluginPaths()) > 0 {
+		for _, plugin := range ansiblePluginEnvVars {
+			paths := make([]string, 0)
+			for _, path := range playbook.PluginPaths() {
+				paths = append(paths, filepath.Join(path, plugin.subdirectory))
+			}
+			env = append(env, fmt.Sprintf("%s=%s", plugin.envVar, strings.Join(appendEnvPaths(plugin.envVar, paths), ":")))
+		}
+	}
+	return env
+}
+
 // ToCommand serializes the play to an executable Ansible command.
 func (v *Play) ToCommand(ansibleArgs LocalModeAnsibleArgs) (string, error) {
 
@@ -406,6 +478,10 @@ func (v *Play) ToCommand(ansibleArgs LocalModeAnsibleArgs) (string, error) {
 			command = fmt.Sprintf("%s %s=%s", command, ansibleEnvVarRolesPath, strings.Join(rolePaths, ":"))
 		}
 
+		if env := playbookPathsEnvironment(entity); len(env) > 0 {
+			command = fmt.Sprintf("%s %s", command, strings.Join(env, " "))
+		}
+
 		command = fmt.Sprintf("%s ansible-playbook %s", command, entity.FilePath())
 
 		// force handlers:
@@ -514,9 +590,130 @@ func (v *Play) ToLocalCommand(ansibleArgs LocalModeAnsibleArgs, ansibleSSHSettin
 		return baseCommand, nil
 	}
 
+	if env := v.toLocalEnvironment(ansibleArgs, ansibleSSHSettings); len(env) > 0 {
+		baseCommand = fmt.Sprintf("%s %s", strings.Join(env, " "), baseCommand)
+	}
+
 	return fmt.Sprintf("%s %s", baseCommand, v.toCommandArguments(ansibleArgs, ansibleSSHSettings)), nil
 }
 
+// UnsupportedPullArguments returns the attributes of the play ansible-pull does not pass to the playbook:
+// become, forks, force handlers and start at task.
+func (v *Play) UnsupportedPullArguments() []string {
+	unsupported := make([]string, 0)
+	if v.Become() {
+		unsupported = append(unsupported, playAttributeBecome)
+	}
+	if v.Forks() != playDefaultForks {
+		unsupported = append(unsupported, playAttributeForks)
+	}
+	if entity, ok := v.Entity().(*Playbook); ok {
+		if entity.ForceHandlers() {
+			unsupported = append(unsupported, ansiblePlaybookAttributeForceHandlers)
+		}
+		if entity.StartAtTask() != "" {
+			unsupported = append(unsupported, ansiblePlaybookAttributeStartAtTask)
+		}
+	}
+	return unsupported
+}
+
+// ToPullCommand serializes a playbook play to an ansible-pull command running the playbook from a checkout
+// of the repository. ansible-pull passes only a subset of the ansible-playbook arguments to the playbook,
+// a play setting any of the unsupported pull arguments is rejected. Without a limit, every host
+// of the inventory is provisioned, ansible-pull would otherwise limit the run to the host name.
+func (v *Play) ToPullCommand(pullArgs RemotePullArgs) (string, error) {
+	entity, ok := v.Entity().(*Playbook)
+	if !ok {
+		return "", errors.New("Only playbooks can be run with ansible-pull")
+	}
+	if unsupported := v.UnsupportedPullArguments(); len(unsupported) > 0 {
+		return "", fmt.Errorf("ansible-pull does not pass %s to the playbook", strings.Join(unsupported, ", "))
+	}
+
+	command := fmt.Sprintf("%s=true", ansibleEnvVarForceColor)
+	if envVarVal, ok := os.LookupEnv(ansibleEnvVarRemoteTmp); ok {
+		command = fmt.Sprintf("%s %s=\"%s\"", command, ansibleEnvVarRemoteTmp, envVarVal)
+	}
+	command = fmt.Sprintf("%s ansible-pull --url='%s' --directory='%s'", command, pullArgs.URL, pullArgs.Directory)
+	if pullArgs.Checkout != "" {
+		command = fmt.Sprintf("%s --checkout='%s'", command, pullArgs.Checkout)
+	}
+	if pullArgs.PrivateKeyFile != "" {
+		command = fmt.Sprintf("%s --private-key='%s'", command, pullArgs.PrivateKeyFile)
+	}
+	if pullArgs.AcceptHostKey {
+		command = fmt.Sprintf("%s --accept-host-key", command)
+	}
+
+	// skip tags:
+	if len(entity.SkipTags()) > 0 {
+		command = fmt.Sprintf("%s --skip-tags='%s'", command, strings.Join(entity.SkipTags(), ","))
+	}
+	// tags:
+	if len(entity.Tags()) > 0 {
+		command = fmt.Sprintf("%s --tags='%s'", command, strings.Join(entity.Tags(), ","))
+	}
+	// inventory file:
+	command = fmt.Sprintf("%s --inventory-file='%s'", command, v.InventoryFile())
+	// limit:
+	if v.Limit() != "" {
+		command = fmt.Sprintf("%s --limit='%s'", command, v.Limit())
+	} else {
+		command = fmt.Sprintf("%s --limit='all'", command)
+	}
+	// diff:
+	if v.Diff() {
+		command = fmt.Sprintf("%s --diff", command)
+	}
+	// check:
+	if v.Check() {
+		command = fmt.Sprintf("%s --check", command)
+	}
+	// extra vars:
+	if len(v.ExtraVars()) > 0 {
+		extraVars, err := json.Marshal(v.ExtraVars())
+		if err != nil {
+			return "", err
+		}
+		singleQuotteEscape := shellescape.NewSingleQuoteEscape(string(extraVars))
+		command = fmt.Sprintf("%s --extra-vars='%s'", command, singleQuotteEscape.Safe())
+	}
+	if len(v.VaultID()) > 0 {
+		for _, vaultID := range v.VaultID() {
+			command = fmt.Sprintf("%s --vault-id='%s'", command, filepath.Clean(vaultID))
+		}
+	} else {
+		// vault password file:
+		if v.VaultPasswordFile() != "" {
+			command = fmt.Sprintf("%s --vault-password-file='%s'", command, v.VaultPasswordFile())
+		}
+	}
+	// verbose:
+	if v.Verbose() {
+		command = fmt.Sprintf("%s --verbose", command)
+	}
+
+	return fmt.Sprintf("%s '%s'", command, pullArgs.Playbook), nil
+}
+
+// toLocalEnvironment returns Ansible environment variables controlling SSH connection reuse.
+// ControlPersist has to be given to Ansible via ANSIBLE_SSH_ARGS, ssh uses the first value
+// of an option and Ansible puts its own ssh_args in front of --ssh-extra-args.
+func (v *Play) toLocalEnvironment(ansibleArgs LocalModeAnsibleArgs, ansibleSSHSettings *AnsibleSSHSettings) []string {
+	env := make([]string, 0)
+	if ansibleSSHSettings.ControlPersistSeconds() > 0 && ansibleArgs.ControlPathDir != "" {
+		env = append(env, fmt.Sprintf("%s=\"-C -o ControlMaster=auto -o ControlPersist=%ds\"",
+			ansibleEnvVarSSHArgs,
+			ansibleSSHSettings.ControlPersistSeconds()))
+		env = append(env, fmt.Sprintf("%s='%s'", ansibleEnvVarControlPathDir, ansibleArgs.ControlPathDir))
+	}
+	if ansibleSSHSettings.Pipelining() {
+		env = append(env, fmt.Sprintf("%s=True", ansibleEnvVarPipelining))
+	}
+	return env
+}
+
 func (v *Play) appendSharedArguments(command string, ansibleArgs LocalModeAnsibleArgs) (string, error) {
 
 	// inventory file:
@@ -579,6 +776,12 @@ func (v *Play) appendSharedArguments(command string, ansibleArgs LocalModeAnsibl
 
 func (v *Play) toCommandArguments(ansibleArgs LocalModeAnsibleArgs, ansibleSSHSettings *AnsibleSSHSettings) string {
 	args := fmt.Sprintf("--user='%s'", ansibleArgs.Username)
+
+	// the generated ssh_config carries the complete connection setup:
+	if ansibleArgs.SSHConfigFile != "" {
+		return fmt.Sprintf("%s --ssh-common-args='-F %s'", args, ansibleArgs.SSHConfigFile)
+	}
+
 	if ansibleArgs.PemFile != "" {
 		args = fmt.Sprintf("%s --private-key='%s'", args, ansibleArgs.PemFile)
 	}
@@ -587,8 +790,9 @@ func (v *Play) toCommandArguments(ansibleArgs LocalModeAnsibleArgs, ansibleSSHSe
 	sshExtraAgrsOptions = append(sshExtraAgrsOptions, fmt.Sprintf("-p %d", ansibleArgs.Port))
 	sshExtraAgrsOptions = append(sshExtraAgrsOptions, fmt.Sprintf("-o ConnectTimeout=%d", ansibleSSHSettings.ConnectTimeoutSeconds()))
 	sshExtraAgrsOptions = append(sshExtraAgrsOptions, fmt.Sprintf("-o ConnectionAttempts=%d", ansibleSSHSettings.ConnectAttempts()))
+	sshExtraAgrsOptions = append(sshExtraAgrsOptions, ansibleSSHSettings.serverAliveOptions()...)
 
-	if ansibleSSHSettings.InsecureNoStrictHostKeyChecking() || v.InventoryFile() != "" {
+	if ansibleSSHSettings.InsecureNoStrictHostKeyChecking() {
 		sshExtraAgrsOptions = append(sshExtraAgrsOptions, "-o StrictHostKeyChecking=no")
 	} else {
 		if ansibleSSHSettings.UserKnownHostsFile() != "" {
@@ -600,7 +804,11 @@ func (v *Play) toCommandArguments(ansibleArgs LocalModeAnsibleArgs, ansibleSSHSe
 	if ansibleArgs.BastionHost != "" {
 		proxyCommand := "-o ProxyCommand=\"ssh"
 		proxyCommand = fmt.Sprintf("%s -p %d", proxyCommand, ansibleArgs.BastionPort)
-		proxyCommand = fmt.Sprintf("%s -W %%h:%%p %s@%s", proxyCommand, ansibleArgs.BastionUsername, ansibleArgs.BastionHost)
+		for _, option := range ansibleSSHSettings.serverAliveOptions() {
+			proxyCommand = fmt.Sprintf("%s %s", proxyCommand, option)
+		}
+		// brackets around %h keep IPv6 targets apart from the port:
+		proxyCommand = fmt.Sprintf("%s -W [%%h]:%%p %s@%s", proxyCommand, ansibleArgs.BastionUsername, ansibleArgs.BastionHost)
 		if ansibleArgs.BastionPemFile != "" {
 			proxyCommand = fmt.Sprintf("%s -i %s", proxyCommand, ansibleArgs.BastionPemFile)
 		}
@@ -622,6 +830,9 @@ func (v *Play) toCommandArguments(ansibleArgs LocalModeAnsibleArgs, ansibleSSHSe
 	}
 
 	args = fmt.Sprintf("%s --ssh-extra-args='%s'", args, strings.Join(sshExtraAgrsOptions, " "))
+	if ansibleSSHSettings.SSHConfigFile() != "" {
+		args = fmt.Sprintf("%s --ssh-common-args='-F %s'", args, ansibleSSHSettings.SSHConfigFile())
+	}
 
 	return args
 }
@@ -0,0 +1,306 @@
+package types_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/radekg/terraform-provisioner-ansible/v2/test"
+	"github.com/radekg/terraform-provisioner-ansible/v2/types"
+)
+
+func getTestModulePlay(t *testing.T, raw map[string]interface{}) *types.Play {
+	playModuleRawConfigs := test.GetPlayModuleSchema(t, "ping")
+	playModule := map[string]interface{}{
+		"enabled":             true,
+		"become":              false,
+		"become_method":       "sudo",
+		"become_user":         "root",
+		"diff":                false,
+		"check":               false,
+		"forks":               5,
+		"inventory_file":      "",
+		"limit":               "",
+		"vault_id":            []interface{}{},
+		"vault_password_file": "",
+		"verbose":             false,
+		"extra_vars":          map[string]interface{}{},
+		"module":              playModuleRawConfigs.Get("module").(*schema.Set),
+		"playbook":            playModuleRawConfigs.Get("playbook").(*schema.Set),
+	}
+	for k, v := range raw {
+		playModule[k] = v
+	}
+	return types.NewPlayFromMapInterface(playModule, types.NewDefaultsFromMapInterface(map[string]interface{}{}, false))
+}
+
+func TestLocalCommandConnectionReuse(t *testing.T) {
+	play := getTestModulePlay(t, map[string]interface{}{})
+	ansibleSSHSettings := test.GetNewAnsibleSSHSettings(t, map[string]interface{}{
+		"control_persist":        120,
+		"pipelining":             true,
+		"server_alive_interval":  15,
+		"server_alive_count_max": 4,
+	})
+
+	command, err := play.ToLocalCommand(types.LocalModeAnsibleArgs{
+		Username:        "test-user",
+		Port:            22,
+		KnownHostsFile:  "/tmp/known_hosts",
+		BastionHost:     "10.0.0.1",
+		BastionPort:     2222,
+		BastionUsername: "bastion-user",
+		ControlPathDir:  "/tmp/tf-ansible-cp-test",
+	}, ansibleSSHSettings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"ANSIBLE_SSH_ARGS=\"-C -o ControlMaster=auto -o ControlPersist=120s\"",
+		"ANSIBLE_SSH_CONTROL_PATH_DIR='/tmp/tf-ansible-cp-test'",
+		"ANSIBLE_PIPELINING=True",
+		"-o ServerAliveInterval=15 -o ServerAliveCountMax=4",
+		"ProxyCommand=\"ssh -p 2222 -o ServerAliveInterval=15 -o ServerAliveCountMax=4",
+	}
+	for _, e := range expected {
+		if !strings.Contains(command, e) {
+			t.Fatalf("Expected '%s' in command but got: %s", e, command)
+		}
+	}
+}
+
+func TestLocalCommandWithoutConnectionReuse(t *testing.T) {
+	play := getTestModulePlay(t, map[string]interface{}{})
+	command, err := play.ToLocalCommand(types.LocalModeAnsibleArgs{
+		Username: "test-user",
+		Port:     22,
+	}, types.NewAnsibleSSHSettingsFromInterface(nil, false))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, e := range []string{"ANSIBLE_SSH_ARGS", "ANSIBLE_PIPELINING", "ServerAlive"} {
+		if strings.Contains(command, e) {
+			t.Fatalf("Did not expect '%s' in command but got: %s", e, command)
+		}
+	}
+}
+
+func TestLocalCommandUserSSHConfigFile(t *testing.T) {
+	play := getTestModulePlay(t, map[string]interface{}{})
+	ansibleSSHSettings := test.GetNewAnsibleSSHSettings(t, map[string]interface{}{
+		"ssh_config_file": "/home/test-user/.ssh/project_config",
+	})
+	command, err := play.ToLocalCommand(types.LocalModeAnsibleArgs{
+		Username:       "test-user",
+		Port:           22,
+		KnownHostsFile: "/tmp/known_hosts",
+	}, ansibleSSHSettings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, e := range []string{"--ssh-extra-args='-p 22", "--ssh-common-args='-F /home/test-user/.ssh/project_config'"} {
+		if !strings.Contains(command, e) {
+			t.Fatalf("Expected '%s' in command but got: %s", e, command)
+		}
+	}
+}
+
+func TestLocalCommandGeneratedSSHConfig(t *testing.T) {
+	play := getTestModulePlay(t, map[string]interface{}{})
+	ansibleSSHSettings := test.GetNewAnsibleSSHSettings(t, map[string]interface{}{
+		"generate_ssh_config":    true,
+		"ssh_config_file":        "/home/test-user/.ssh/project_config",
+		"server_alive_interval":  15,
+		"server_alive_count_max": 4,
+	})
+	ansibleArgs := types.LocalModeAnsibleArgs{
+		Username:              "test-user",
+		Port:                  2022,
+		PemFile:               "/tmp/target.pem",
+		KnownHostsFile:        "/tmp/known_hosts",
+		BastionKnownHostsFile: "/tmp/bastion_known_hosts",
+		BastionHost:           "10.0.0.1",
+		BastionPort:           2222,
+		BastionUsername:       "bastion-user",
+		BastionPemFile:        "/tmp/bastion.pem",
+		SSHConfigFile:         "/tmp/ssh_config",
+	}
+
+	command, err := play.ToLocalCommand(ansibleArgs, ansibleSSHSettings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(command, "--user='test-user' --ssh-common-args='-F /tmp/ssh_config'") {
+		t.Fatalf("Expected generated ssh_config in command but got: %s", command)
+	}
+	for _, e := range []string{"--ssh-extra-args", "--private-key", "ProxyCommand"} {
+		if strings.Contains(command, e) {
+			t.Fatalf("Did not expect '%s' in command but got: %s", e, command)
+		}
+	}
+
+	sshConfig, err := play.ToSSHConfig(ansibleArgs, ansibleSSHSettings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{
+		"Host tf-ansible-bastion\n  HostName 10.0.0.1\n  User bastion-user\n  Port 2222\n  IdentityFile /tmp/bastion.pem\n",
+		"  UserKnownHostsFile /tmp/bastion_known_hosts\n",
+		"Host * !tf-ansible-bastion\n  User test-user\n  Port 2022\n  IdentityFile /tmp/target.pem\n",
+		"  UserKnownHostsFile /tmp/known_hosts\n",
+		"  ServerAliveInterval 15\n  ServerAliveCountMax 4\n",
+		"  ProxyJump tf-ansible-bastion\n",
+		"Match all\n  Include /home/test-user/.ssh/project_config\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(sshConfig, e) {
+			t.Fatalf("Expected '%s' in ssh_config but got:\n%s", e, sshConfig)
+		}
+	}
+}
+
+func TestLocalCommandIPv6Bastion(t *testing.T) {
+	play := getTestModulePlay(t, map[string]interface{}{})
+	command, err := play.ToLocalCommand(types.LocalModeAnsibleArgs{
+		Username:              "test-user",
+		Port:                  22,
+		KnownHostsFile:        "/tmp/known_hosts",
+		BastionKnownHostsFile: "/tmp/bastion_known_hosts",
+		BastionHost:           "2001:db8::1",
+		BastionPort:           2222,
+		BastionUsername:       "bastion-user",
+	}, types.NewAnsibleSSHSettingsFromInterface(nil, false))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "ProxyCommand=\"ssh -p 2222 -W [%h]:%p bastion-user@2001:db8::1"
+	if !strings.Contains(command, expected) {
+		t.Fatalf("Expected '%s' in command but got: %s", expected, command)
+	}
+}
+
+func TestPlaybookCommandPathsEnvironment(t *testing.T) {
+	playPlaybookRawConfigs := schema.TestResourceDataRaw(t, map[string]*schema.Schema{
+		"module":   types.NewModuleSchema(),
+		"playbook": types.NewPlaybookSchema(),
+	}, map[string]interface{}{
+		"playbook": []interface{}{
+			map[string]interface{}{
+				"file_path":        "/project/site.yml",
+				"collections_path": []interface{}{"/project/collections"},
+				"library_path":     []interface{}{"/project/library", "/shared/library"},
+				"plugin_paths":     []interface{}{"/project"},
+			},
+		},
+		"module": []interface{}{},
+	})
+	play := getTestModulePlay(t, map[string]interface{}{
+		"module":   playPlaybookRawConfigs.Get("module").(*schema.Set),
+		"playbook": playPlaybookRawConfigs.Get("playbook").(*schema.Set),
+	})
+	command, err := play.ToCommand(types.LocalModeAnsibleArgs{Username: "test-user"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, expected := range []string{
+		"ANSIBLE_COLLECTIONS_PATHS=/project/collections ",
+		"ANSIBLE_LIBRARY=/project/library:/shared/library ",
+		"ANSIBLE_FILTER_PLUGINS=/project/filter_plugins ",
+		"ANSIBLE_MODULE_UTILS=/project/module_utils ",
+	} {
+		if !strings.Contains(command, expected) {
+			t.Fatalf("Expected '%s' in command but got: %s", expected, command)
+		}
+	}
+	if strings.Index(command, "ANSIBLE_LIBRARY") > strings.Index(command, "ansible-playbook") {
+		t.Fatalf("Expected the environment before ansible-playbook but got: %s", command)
+	}
+}
+
+func TestPlaybookPullCommand(t *testing.T) {
+	playPlaybookRawConfigs := schema.TestResourceDataRaw(t, map[string]*schema.Schema{
+		"module":   types.NewModuleSchema(),
+		"playbook": types.NewPlaybookSchema(),
+	}, map[string]interface{}{
+		"playbook": []interface{}{
+			map[string]interface{}{
+				"file_path": "/project/site.yml",
+				"tags":      []interface{}{"web"},
+			},
+		},
+		"module": []interface{}{},
+	})
+	play := getTestModulePlay(t, map[string]interface{}{
+		"inventory_file": "/tmp/bootstrap/.inventory",
+		"check":          true,
+		"module":         playPlaybookRawConfigs.Get("module").(*schema.Set),
+		"playbook":       playPlaybookRawConfigs.Get("playbook").(*schema.Set),
+	})
+	command, err := play.ToPullCommand(types.RemotePullArgs{
+		URL:            "git@example.com:ops/playbooks.git",
+		Checkout:       "v1.2.0",
+		Directory:      "/tmp/bootstrap/pull",
+		PrivateKeyFile: "/tmp/bootstrap/.pull-key",
+		Playbook:       "playbooks/site.yml",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := " ansible-pull --url='git@example.com:ops/playbooks.git' --directory='/tmp/bootstrap/pull' --checkout='v1.2.0'" +
+		" --private-key='/tmp/bootstrap/.pull-key' --tags='web' --inventory-file='/tmp/bootstrap/.inventory'" +
+		" --limit='all' --check 'playbooks/site.yml'"
+	if !strings.HasPrefix(command, "ANSIBLE_FORCE_COLOR=true") || !strings.HasSuffix(command, expected) {
+		t.Fatalf("Expected a command ending with:\n%s\nbut got:\n%s", expected, command)
+	}
+
+	// the host key of the git server is only accepted when asked for:
+	command, err = play.ToPullCommand(types.RemotePullArgs{
+		URL:           "git@example.com:ops/playbooks.git",
+		Directory:     "/tmp/bootstrap/pull",
+		AcceptHostKey: true,
+		Playbook:      "playbooks/site.yml",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(command, " --directory='/tmp/bootstrap/pull' --accept-host-key --tags='web'") {
+		t.Fatalf("Expected the host key to be accepted but got:\n%s", command)
+	}
+
+	// ansible-pull does not pass these to the playbook, the play is rejected instead of running without them:
+	for _, raw := range []map[string]interface{}{
+		{"become": true},
+		{"forks": 10},
+	} {
+		raw["module"] = playPlaybookRawConfigs.Get("module").(*schema.Set)
+		raw["playbook"] = playPlaybookRawConfigs.Get("playbook").(*schema.Set)
+		if _, err := getTestModulePlay(t, raw).ToPullCommand(types.RemotePullArgs{Playbook: "site.yml"}); err == nil {
+			t.Fatalf("Expected a play with %v to be rejected", raw)
+		}
+	}
+	for _, playbook := range []map[string]interface{}{
+		{"file_path": "/project/site.yml", "force_handlers": true},
+		{"file_path": "/project/site.yml", "start_at_task": "deploy"},
+	} {
+		raw := schema.TestResourceDataRaw(t, map[string]*schema.Schema{
+			"module":   types.NewModuleSchema(),
+			"playbook": types.NewPlaybookSchema(),
+		}, map[string]interface{}{
+			"playbook": []interface{}{playbook},
+			"module":   []interface{}{},
+		})
+		unsupportedPlay := getTestModulePlay(t, map[string]interface{}{
+			"module":   raw.Get("module").(*schema.Set),
+			"playbook": raw.Get("playbook").(*schema.Set),
+		})
+		if _, err := unsupportedPlay.ToPullCommand(types.RemotePullArgs{Playbook: "site.yml"}); err == nil {
+			t.Fatalf("Expected a playbook with %v to be rejected", playbook)
+		}
+	}
+
+	// modules run with ansible, there is nothing to pull:
+	if _, err := getTestModulePlay(t, map[string]interface{}{}).ToPullCommand(types.RemotePullArgs{}); err == nil {
+		t.Fatal("Expected a module play to be rejected")
+	}
+}
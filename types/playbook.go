@@ -5,12 +5,17 @@ import (
 )
 
 const (
-	ansiblePlaybookAttributeForceHandlers = "force_handlers"
-	ansiblePlaybookAttributeSkipTags      = "skip_tags"
-	ansiblePlaybookAttributeStartAtTask   = "start_at_task"
-	ansiblePlaybookAttributeTags          = "tags"
-	ansiblePlaybookAttributeFilePath      = "file_path"
-	ansiblePlaybookAttributeRolesPath     = "roles_path"
+	ansiblePlaybookAttributeForceHandlers   = "force_handlers"
+	ansiblePlaybookAttributeSkipTags        = "skip_tags"
+	ansiblePlaybookAttributeStartAtTask     = "start_at_task"
+	ansiblePlaybookAttributeTags            = "tags"
+	ansiblePlaybookAttributeFilePath        = "file_path"
+	ansiblePlaybookAttributeRolesPath       = "roles_path"
+	ansiblePlaybookAttributeUploadExclude   = "upload_exclude"
+	ansiblePlaybookAttributeProjectRoot     = "project_root"
+	ansiblePlaybookAttributeCollectionsPath = "collections_path"
+	ansiblePlaybookAttributeLibraryPath     = "library_path"
+	ansiblePlaybookAttributePluginPaths     = "plugin_paths"
 )
 
 // Playbook represents playbook settings.
@@ -21,10 +26,21 @@ type Playbook struct {
 	tags          []string
 	filePath      string
 	rolesPath     []string
+	uploadExclude []string
+	projectRoot   string
+
+	collectionsPath []string
+	libraryPath     []string
+	pluginPaths     []string
 
 	// when running a remote provisioner, the path will changed to the remote path:
-	overrideFilePath  string
-	overrideRolesPath []string
+	overrideFilePath    string
+	overrideRolesPath   []string
+	overrideProjectRoot string
+
+	overrideCollectionsPath []string
+	overrideLibraryPath     []string
+	overridePluginPaths     []string
 }
 
 // NewPlaybookSchema returns a new Ansible playbook schema.
@@ -55,16 +71,41 @@ func NewPlaybookSchema() *schema.Schema {
 					Optional: true,
 				},
 				// operational:
+				// the existence of the file is validated by the provisioner, with remote.pull the path is in the repository:
 				ansiblePlaybookAttributeFilePath: &schema.Schema{
-					Type:         schema.TypeString,
-					Required:     true,
-					ValidateFunc: vfPath,
+					Type:     schema.TypeString,
+					Required: true,
 				},
 				ansiblePlaybookAttributeRolesPath: &schema.Schema{
 					Type:     schema.TypeList,
 					Elem:     &schema.Schema{Type: schema.TypeString},
 					Optional: true,
 				},
+				ansiblePlaybookAttributeCollectionsPath: &schema.Schema{
+					Type:     schema.TypeList,
+					Elem:     &schema.Schema{Type: schema.TypeString},
+					Optional: true,
+				},
+				ansiblePlaybookAttributeLibraryPath: &schema.Schema{
+					Type:     schema.TypeList,
+					Elem:     &schema.Schema{Type: schema.TypeString},
+					Optional: true,
+				},
+				ansiblePlaybookAttributePluginPaths: &schema.Schema{
+					Type:     schema.TypeList,
+					Elem:     &schema.Schema{Type: schema.TypeString},
+					Optional: true,
+				},
+				ansiblePlaybookAttributeProjectRoot: &schema.Schema{
+					Type:         schema.TypeString,
+					Optional:     true,
+					ValidateFunc: VfPathDirectory,
+				},
+				ansiblePlaybookAttributeUploadExclude: &schema.Schema{
+					Type:     schema.TypeList,
+					Elem:     &schema.Schema{Type: schema.TypeString},
+					Optional: true,
+				},
 			},
 		},
 	}
@@ -80,6 +121,12 @@ func NewPlaybookFromInterface(i interface{}) *Playbook {
 		startAtTask:   vals[ansiblePlaybookAttributeStartAtTask].(string),
 		tags:          listOfInterfaceToListOfString(vals[ansiblePlaybookAttributeTags].([]interface{})),
 		rolesPath:     listOfInterfaceToListOfString(vals[ansiblePlaybookAttributeRolesPath].([]interface{})),
+		uploadExclude: listOfInterfaceToListOfString(vals[ansiblePlaybookAttributeUploadExclude].([]interface{})),
+		projectRoot:   vals[ansiblePlaybookAttributeProjectRoot].(string),
+
+		collectionsPath: listOfInterfaceToListOfString(vals[ansiblePlaybookAttributeCollectionsPath].([]interface{})),
+		libraryPath:     listOfInterfaceToListOfString(vals[ansiblePlaybookAttributeLibraryPath].([]interface{})),
+		pluginPaths:     listOfInterfaceToListOfString(vals[ansiblePlaybookAttributePluginPaths].([]interface{})),
 	}
 }
 
@@ -120,6 +167,46 @@ func (v *Playbook) RolesPath() []string {
 	return v.overrideRolesPath
 }
 
+// CollectionsPath appends collection directories to ANSIBLE_COLLECTIONS_PATHS environment variable.
+func (v *Playbook) CollectionsPath() []string {
+	if len(v.overrideCollectionsPath) == 0 {
+		return v.collectionsPath
+	}
+	return v.overrideCollectionsPath
+}
+
+// LibraryPath appends module directories to ANSIBLE_LIBRARY environment variable.
+func (v *Playbook) LibraryPath() []string {
+	if len(v.overrideLibraryPath) == 0 {
+		return v.libraryPath
+	}
+	return v.overrideLibraryPath
+}
+
+// PluginPaths returns directories with the action_plugins, filter_plugins, module_utils and such subdirectories,
+// the subdirectories are appended to the respective plugin environment variables.
+func (v *Playbook) PluginPaths() []string {
+	if len(v.overridePluginPaths) == 0 {
+		return v.pluginPaths
+	}
+	return v.overridePluginPaths
+}
+
+// ProjectRoot returns the directory containing the playbook and the files it references.
+// The remote provisioner uploads the complete directory, both provisioners run Ansible in it.
+func (v *Playbook) ProjectRoot() string {
+	if v.overrideProjectRoot == "" {
+		return v.projectRoot
+	}
+	return v.overrideProjectRoot
+}
+
+// UploadExclude returns gitignore style patterns of files not uploaded
+// with the playbook directory and roles paths by the remote provisioner.
+func (v *Playbook) UploadExclude() []string {
+	return v.uploadExclude
+}
+
 // SetOverrideFilePath is used by the remote provisioner to reference the correct
 // playbook location after the upload to the provisioned machine.
 func (v *Playbook) SetOverrideFilePath(path string) {
@@ -131,3 +218,27 @@ func (v *Playbook) SetOverrideFilePath(path string) {
 func (v *Playbook) SetOverrideRolesPath(path []string) {
 	v.overrideRolesPath = path
 }
+
+// SetOverrideProjectRoot is used by the remote provisioner to reference the correct
+// project location after the upload to the provisioned machine.
+func (v *Playbook) SetOverrideProjectRoot(path string) {
+	v.overrideProjectRoot = path
+}
+
+// SetOverrideCollectionsPath is used by the remote provisioner to reference the correct
+// collection locations after the upload to the provisioned machine.
+func (v *Playbook) SetOverrideCollectionsPath(path []string) {
+	v.overrideCollectionsPath = path
+}
+
+// SetOverrideLibraryPath is used by the remote provisioner to reference the correct
+// module locations after the upload to the provisioned machine.
+func (v *Playbook) SetOverrideLibraryPath(path []string) {
+	v.overrideLibraryPath = path
+}
+
+// SetOverridePluginPaths is used by the remote provisioner to reference the correct
+// plugin locations after the upload to the provisioned machine.
+func (v *Playbook) SetOverridePluginPaths(path []string) {
+	v.overridePluginPaths = path
+}
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 
 	"github.com/hashicorp/terraform/helper/schema"
+	homedir "github.com/mitchellh/go-homedir"
 )
 
 // RemoteSettings represents remote settings.
@@ -13,26 +14,84 @@ type RemoteSettings struct {
 	useSudo                  bool
 	skipInstall              bool
 	skipCleanup              bool
+	cleanup                  string
 	installVersion           string
+	installMethod            string
+	installPackage           string
+	offlineBundle            string
 	localInstallerPath       string
 	remoteInstallerDirectory string
 	bootstrapDirectory       string
+	uploadExclude            []string
+	network                  *RemoteNetworkSettings
+	pull                     *RemotePullSettings
+	uploadCache              bool
+	becomeCommand            string
+	becomeUser               string
+	becomePassword           string
+	forwardAgent             bool
+	fetch                    []*RemoteFetch
+	logDownloadPath          string
+	detached                 bool
+
+	// the remote provisioner creates a private bootstrap directory per run:
+	overrideBootstrapDirectory string
+	// the remote provisioner falls back to the home directory when the installer directory is noexec:
+	overrideRemoteInstallerDirectory string
 }
 
+const (
+	// RemoteCleanupAlways removes the bootstrap directory after every run.
+	RemoteCleanupAlways = "always"
+	// RemoteCleanupOnSuccess removes the bootstrap directory when all plays succeed.
+	RemoteCleanupOnSuccess = "on_success"
+	// RemoteCleanupNever keeps the bootstrap directory.
+	RemoteCleanupNever = "never"
+)
+
+const (
+	// RemoteBecomeSudo runs privileged commands with sudo.
+	RemoteBecomeSudo = "sudo"
+	// RemoteBecomeDoas runs privileged commands with doas.
+	RemoteBecomeDoas = "doas"
+	// RemoteBecomeSu runs privileged commands with su.
+	RemoteBecomeSu = "su"
+	// RemoteBecomePbrun runs privileged commands with pbrun.
+	RemoteBecomePbrun = "pbrun"
+)
+
 const (
 	// default values:
 	remoteDefaultUseSudo                  = true
 	remoteDefaultInstallVersion           = "" // latest
+	remoteDefaultInstallMethod            = "pip"
+	remoteDefaultInstallPackage           = "ansible"
 	remoteDefaultRemoteInstallerDirectory = "/tmp"
 	remoteDefaultBootstrapDirectory       = "/tmp"
+	remoteDefaultBecomeCommand            = RemoteBecomeSudo
 	// attribute names:
 	remoteAttributeUseSudo                  = "use_sudo"
 	remoteAttributeSkipInstall              = "skip_install"
 	remoteAttributeSkipCleanup              = "skip_cleanup"
+	remoteAttributeCleanup                  = "cleanup"
 	remoteAttributeInstallVersion           = "install_version"
+	remoteAttributeInstallMethod            = "install_method"
+	remoteAttributeInstallPackage           = "install_package"
+	remoteAttributeOfflineBundle            = "offline_bundle"
 	remoteAttributeLocalInstallerPath       = "local_installer_path"
 	remoteAttributeRemoteInstallerDirectory = "remote_installer_directory"
 	remoteAttributeBootstrapDirectory       = "bootstrap_directory"
+	remoteAttributeUploadExclude            = "upload_exclude"
+	remoteAttributeNetwork                  = "network"
+	remoteAttributeUploadCache              = "upload_cache"
+	remoteAttributeBecomeCommand            = "become_command"
+	remoteAttributeBecomeUser               = "become_user"
+	remoteAttributeBecomePassword           = "become_password"
+	remoteAttributeForwardAgent             = "forward_agent"
+	remoteAttributeFetch                    = "fetch"
+	remoteAttributeLogDownloadPath          = "log_download_path"
+	remoteAttributeDetached                 = "detached"
+	remoteAttributePull                     = "pull"
 )
 
 // NewRemoteSchema returns a new remote schema.
@@ -52,8 +111,15 @@ func NewRemoteSchema() *schema.Schema {
 					Optional: true,
 				},
 				remoteAttributeSkipCleanup: &schema.Schema{
-					Type:     schema.TypeBool,
-					Optional: true,
+					Type:          schema.TypeBool,
+					Optional:      true,
+					ConflictsWith: []string{fmt.Sprintf("remote.%s", remoteAttributeCleanup)},
+				},
+				remoteAttributeCleanup: &schema.Schema{
+					Type:          schema.TypeString,
+					Optional:      true,
+					ValidateFunc:  vfCleanupPolicy,
+					ConflictsWith: []string{fmt.Sprintf("remote.%s", remoteAttributeSkipCleanup)},
 				},
 				remoteAttributeInstallVersion: &schema.Schema{
 					Type:          schema.TypeString,
@@ -61,11 +127,40 @@ func NewRemoteSchema() *schema.Schema {
 					Default:       remoteDefaultInstallVersion,
 					ConflictsWith: []string{fmt.Sprintf("remote.%s", remoteAttributeLocalInstallerPath)},
 				},
-				remoteAttributeLocalInstallerPath: &schema.Schema{
+				remoteAttributeInstallMethod: &schema.Schema{
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      remoteDefaultInstallMethod,
+					ValidateFunc: vfInstallMethod,
+					ConflictsWith: []string{
+						fmt.Sprintf("remote.%s", remoteAttributeLocalInstallerPath),
+						fmt.Sprintf("remote.%s", remoteAttributeOfflineBundle),
+					},
+				},
+				remoteAttributeInstallPackage: &schema.Schema{
 					Type:          schema.TypeString,
 					Optional:      true,
-					ValidateFunc:  vfPath,
-					ConflictsWith: []string{fmt.Sprintf("remote.%s", remoteAttributeInstallVersion)},
+					Default:       remoteDefaultInstallPackage,
+					ValidateFunc:  vfInstallPackage,
+					ConflictsWith: []string{fmt.Sprintf("remote.%s", remoteAttributeLocalInstallerPath)},
+				},
+				remoteAttributeOfflineBundle: &schema.Schema{
+					Type:         schema.TypeString,
+					Optional:     true,
+					ValidateFunc: vfOfflineBundle,
+					ConflictsWith: []string{
+						fmt.Sprintf("remote.%s", remoteAttributeLocalInstallerPath),
+						fmt.Sprintf("remote.%s", remoteAttributeInstallMethod),
+					},
+				},
+				remoteAttributeLocalInstallerPath: &schema.Schema{
+					Type:         schema.TypeString,
+					Optional:     true,
+					ValidateFunc: VfPath,
+					ConflictsWith: []string{
+						fmt.Sprintf("remote.%s", remoteAttributeInstallVersion),
+						fmt.Sprintf("remote.%s", remoteAttributeOfflineBundle),
+					},
 				},
 				remoteAttributeRemoteInstallerDirectory: &schema.Schema{
 					Type:     schema.TypeString,
@@ -77,6 +172,47 @@ func NewRemoteSchema() *schema.Schema {
 					Optional: true,
 					Default:  remoteDefaultBootstrapDirectory,
 				},
+				remoteAttributeUploadExclude: &schema.Schema{
+					Type:     schema.TypeList,
+					Elem:     &schema.Schema{Type: schema.TypeString},
+					Optional: true,
+				},
+				remoteAttributeNetwork: NewRemoteNetworkSchema(),
+				remoteAttributeFetch:   NewRemoteFetchSchema(),
+				remoteAttributePull:    NewRemotePullSchema(),
+				remoteAttributeLogDownloadPath: &schema.Schema{
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				remoteAttributeDetached: &schema.Schema{
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  false,
+				},
+				remoteAttributeUploadCache: &schema.Schema{
+					Type:     schema.TypeBool,
+					Optional: true,
+				},
+				remoteAttributeBecomeCommand: &schema.Schema{
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      remoteDefaultBecomeCommand,
+					ValidateFunc: vfRemoteBecomeCommand,
+				},
+				remoteAttributeBecomeUser: &schema.Schema{
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				remoteAttributeBecomePassword: &schema.Schema{
+					Type:      schema.TypeString,
+					Optional:  true,
+					Sensitive: true,
+				},
+				remoteAttributeForwardAgent: &schema.Schema{
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  false,
+				},
 			},
 		},
 	}
@@ -90,6 +226,8 @@ func NewRemoteSettingsFromInterface(i interface{}, ok bool) *RemoteSettings {
 	return &RemoteSettings{
 		isRemoteInUse: false,
 		useSudo:       remoteDefaultUseSudo,
+		network:       NewRemoteNetworkSettingsFromInterface(nil, false),
+		pull:          NewRemotePullSettingsFromInterface(nil, false),
 	}
 }
 
@@ -98,16 +236,55 @@ func NewRemoteSettingsFromMapInterface(vals map[string]interface{}, ok bool) *Re
 	v := &RemoteSettings{
 		isRemoteInUse: false,
 		useSudo:       remoteDefaultUseSudo,
+		network:       NewRemoteNetworkSettingsFromInterface(nil, false),
+		pull:          NewRemotePullSettingsFromInterface(nil, false),
 	}
 	if ok {
 		v.isRemoteInUse = true
 		v.useSudo = vals[remoteAttributeUseSudo].(bool)
 		v.skipInstall = vals[remoteAttributeSkipInstall].(bool)
 		v.skipCleanup = vals[remoteAttributeSkipCleanup].(bool)
+		if val, ok := vals[remoteAttributeCleanup]; ok {
+			v.cleanup = val.(string)
+		}
 		v.installVersion = vals[remoteAttributeInstallVersion].(string)
+		v.installMethod = vals[remoteAttributeInstallMethod].(string)
+		v.installPackage = vals[remoteAttributeInstallPackage].(string)
+		v.offlineBundle = vals[remoteAttributeOfflineBundle].(string)
 		v.localInstallerPath = vals[remoteAttributeLocalInstallerPath].(string)
 		v.remoteInstallerDirectory = vals[remoteAttributeRemoteInstallerDirectory].(string)
 		v.bootstrapDirectory = vals[remoteAttributeBootstrapDirectory].(string)
+		v.uploadExclude = listOfInterfaceToListOfString(vals[remoteAttributeUploadExclude].([]interface{}))
+		if val, ok := vals[remoteAttributeUploadCache]; ok {
+			v.uploadCache = val.(bool)
+		}
+		if val, ok := vals[remoteAttributeBecomeCommand]; ok {
+			v.becomeCommand = val.(string)
+		}
+		if val, ok := vals[remoteAttributeBecomeUser]; ok {
+			v.becomeUser = val.(string)
+		}
+		if val, ok := vals[remoteAttributeBecomePassword]; ok {
+			v.becomePassword = val.(string)
+		}
+		if val, ok := vals[remoteAttributeForwardAgent]; ok {
+			v.forwardAgent = val.(bool)
+		}
+		if val, ok := vals[remoteAttributeNetwork]; ok && val != nil {
+			v.network = NewRemoteNetworkSettingsFromInterface(val, ok)
+		}
+		if val, ok := vals[remoteAttributePull]; ok && val != nil {
+			v.pull = NewRemotePullSettingsFromInterface(val, ok)
+		}
+		if val, ok := vals[remoteAttributeFetch]; ok && val != nil {
+			v.fetch = NewRemoteFetchListFromInterface(val)
+		}
+		if val, ok := vals[remoteAttributeLogDownloadPath]; ok {
+			v.logDownloadPath = val.(string)
+		}
+		if val, ok := vals[remoteAttributeDetached]; ok {
+			v.detached = val.(bool)
+		}
 	}
 	return v
 }
@@ -132,23 +309,142 @@ func (v *RemoteSettings) SkipCleanup() bool {
 	return v.skipCleanup
 }
 
+// Cleanup returns the bootstrap directory cleanup policy: always, on_success or never.
+// Unless given, the policy follows skip_cleanup.
+func (v *RemoteSettings) Cleanup() string {
+	if v.cleanup != "" {
+		return v.cleanup
+	}
+	if v.skipCleanup {
+		return RemoteCleanupNever
+	}
+	return RemoteCleanupOnSuccess
+}
+
 // InstallVersion returns Ansible version to install, empty string means latest.
 func (v *RemoteSettings) InstallVersion() string {
 	return v.installVersion
 }
 
+// InstallMethod returns the way the default installer installs Ansible: pip, venv, pipx or package.
+func (v *RemoteSettings) InstallMethod() string {
+	return v.installMethod
+}
+
+// InstallPackage returns the Ansible package to install: ansible or ansible-core.
+func (v *RemoteSettings) InstallPackage() string {
+	return v.installPackage
+}
+
+// OfflineBundle returns a path to the local directory or tarball of wheels Ansible is installed from without network access.
+func (v *RemoteSettings) OfflineBundle() string {
+	return v.offlineBundle
+}
+
 // LocalInstallerPath returns a path to the custom Ansible installer.
 func (v *RemoteSettings) LocalInstallerPath() string {
 	return v.localInstallerPath
 }
 
+// RemoteInstallerDirectory returns a path to the directory the Ansible installer script is uploaded to.
+func (v *RemoteSettings) RemoteInstallerDirectory() string {
+	if v.overrideRemoteInstallerDirectory != "" {
+		return v.overrideRemoteInstallerDirectory
+	}
+	return v.remoteInstallerDirectory
+}
+
 // RemoteInstallerPath returns a path to the where the Ansible installer script in uploaded to and executed from.
 // This is essentially remote_installer_directory with /ansible-installer appended.
 func (v *RemoteSettings) RemoteInstallerPath() string {
-	return filepath.Join(v.remoteInstallerDirectory, "tf-ansible-installer")
+	return filepath.Join(v.RemoteInstallerDirectory(), "tf-ansible-installer")
+}
+
+// SetOverrideRemoteInstallerDirectory is used by the remote provisioner to reference
+// the directory used instead of a noexec remote_installer_directory.
+func (v *RemoteSettings) SetOverrideRemoteInstallerDirectory(path string) {
+	v.overrideRemoteInstallerDirectory = path
 }
 
 // BootstrapDirectory returns a path to where the playbooks, roles, inventory fiels, vault password / ID files and such are uploded to.
 func (v *RemoteSettings) BootstrapDirectory() string {
+	if v.overrideBootstrapDirectory != "" {
+		return v.overrideBootstrapDirectory
+	}
+	return v.StableBootstrapDirectory()
+}
+
+// StableBootstrapDirectory returns the bootstrap directory shared by all runs, used with upload_cache.
+// This is essentially bootstrap_directory with /tf-ansible-bootstrap appended.
+func (v *RemoteSettings) StableBootstrapDirectory() string {
 	return filepath.Join(v.bootstrapDirectory, "tf-ansible-bootstrap")
 }
+
+// UploadCache returns true when uploads are kept in the stable bootstrap directory and reused by later runs.
+func (v *RemoteSettings) UploadCache() bool {
+	return v.uploadCache
+}
+
+// SetOverrideBootstrapDirectory is used by the remote provisioner to reference
+// the private bootstrap directory of the run.
+func (v *RemoteSettings) SetOverrideBootstrapDirectory(path string) {
+	v.overrideBootstrapDirectory = path
+}
+
+// UploadExclude returns gitignore style patterns of files not uploaded to the bootstrap directory, applied to every play.
+func (v *RemoteSettings) UploadExclude() []string {
+	return v.uploadExclude
+}
+
+// Network returns the network configuration of the remote hosts.
+func (v *RemoteSettings) Network() *RemoteNetworkSettings {
+	return v.network
+}
+
+// Pull returns the git repository playbooks are pulled from with ansible-pull.
+func (v *RemoteSettings) Pull() *RemotePullSettings {
+	return v.pull
+}
+
+// BecomeCommand returns the command running privileged commands when use_sudo is set: sudo, doas, su or pbrun.
+func (v *RemoteSettings) BecomeCommand() string {
+	if v.becomeCommand == "" {
+		return remoteDefaultBecomeCommand
+	}
+	return v.becomeCommand
+}
+
+// BecomeUser returns the user privileged commands run as, empty string means the default user of the become command.
+func (v *RemoteSettings) BecomeUser() string {
+	return v.becomeUser
+}
+
+// BecomePassword returns the password of the become command, given to sudo over stdin.
+func (v *RemoteSettings) BecomePassword() string {
+	return v.becomePassword
+}
+
+// ForwardAgent returns true when the plays run with the local SSH agent forwarded to the host.
+func (v *RemoteSettings) ForwardAgent() bool {
+	return v.forwardAgent
+}
+
+// Fetch returns the paths downloaded from the host after the plays.
+func (v *RemoteSettings) Fetch() []*RemoteFetch {
+	return v.fetch
+}
+
+// LogDownloadPath returns the local directory the Ansible log of every play is downloaded to,
+// empty string when the log is not captured.
+func (v *RemoteSettings) LogDownloadPath() string {
+	if v.logDownloadPath == "" {
+		return ""
+	}
+	expanded, _ := homedir.Expand(v.logDownloadPath)
+	return expanded
+}
+
+// Detached returns true when the plays run detached from the SSH session, surviving disconnects.
+func (v *RemoteSettings) Detached() bool {
+	return v.detached
+}
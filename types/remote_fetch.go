@@ -0,0 +1,98 @@
+package types
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// RemoteFetch represents a path downloaded from the remote host after the plays.
+type RemoteFetch struct {
+	source      string
+	destination string
+	mode        os.FileMode
+}
+
+const (
+	// attribute names:
+	remoteFetchAttributeSource      = "source"
+	remoteFetchAttributeDestination = "destination"
+	remoteFetchAttributeMode        = "mode"
+)
+
+// NewRemoteFetchSchema returns a new remote fetch schema.
+func NewRemoteFetchSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				remoteFetchAttributeSource: &schema.Schema{
+					Type:     schema.TypeString,
+					Required: true,
+				},
+				remoteFetchAttributeDestination: &schema.Schema{
+					Type:     schema.TypeString,
+					Required: true,
+				},
+				remoteFetchAttributeMode: &schema.Schema{
+					Type:         schema.TypeString,
+					Optional:     true,
+					ValidateFunc: vfFileMode,
+				},
+			},
+		},
+	}
+}
+
+// NewRemoteFetchListFromInterface reads the remote fetch blocks from Terraform schema.
+func NewRemoteFetchListFromInterface(i interface{}) []*RemoteFetch {
+	fetch := make([]*RemoteFetch, 0)
+	for _, item := range i.([]interface{}) {
+		fetch = append(fetch, NewRemoteFetchFromMapInterface(item.(map[string]interface{})))
+	}
+	return fetch
+}
+
+// NewRemoteFetchFromMapInterface reads a remote fetch block from a map.
+func NewRemoteFetchFromMapInterface(vals map[string]interface{}) *RemoteFetch {
+	v := &RemoteFetch{
+		source:      vals[remoteFetchAttributeSource].(string),
+		destination: vals[remoteFetchAttributeDestination].(string),
+	}
+	if val, ok := vals[remoteFetchAttributeMode]; ok {
+		v.mode, _ = parseFileMode(val.(string))
+	}
+	return v
+}
+
+// Source returns the remote path of a file or a directory.
+func (v *RemoteFetch) Source() string {
+	return v.source
+}
+
+// Destination returns the local path the source is downloaded to, the directory receiving the contents
+// when the source is a directory.
+func (v *RemoteFetch) Destination() string {
+	expanded, _ := homedir.Expand(v.destination)
+	return expanded
+}
+
+// Mode returns the permissions of the downloaded files, 0 keeps the remote permissions.
+func (v *RemoteFetch) Mode() os.FileMode {
+	return v.mode
+}
+
+func parseFileMode(value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("%s is not a valid octal file mode", value)
+	}
+	return os.FileMode(mode), nil
+}
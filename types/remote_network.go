@@ -0,0 +1,143 @@
+package types
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// RemoteNetworkSettings represents the network configuration of the remote hosts.
+type RemoteNetworkSettings struct {
+	httpProxy      string
+	httpsProxy     string
+	noProxy        string
+	pipIndexURL    string
+	pipTrustedHost string
+	caBundle       string
+}
+
+const (
+	// attribute names:
+	remoteNetworkAttributeHTTPProxy      = "http_proxy"
+	remoteNetworkAttributeHTTPSProxy     = "https_proxy"
+	remoteNetworkAttributeNoProxy        = "no_proxy"
+	remoteNetworkAttributePipIndexURL    = "pip_index_url"
+	remoteNetworkAttributePipTrustedHost = "pip_trusted_host"
+	remoteNetworkAttributeCABundle       = "ca_bundle"
+)
+
+// NewRemoteNetworkSchema returns a new remote network schema.
+func NewRemoteNetworkSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeSet,
+		Optional: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				remoteNetworkAttributeHTTPProxy: &schema.Schema{
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				remoteNetworkAttributeHTTPSProxy: &schema.Schema{
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				remoteNetworkAttributeNoProxy: &schema.Schema{
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				remoteNetworkAttributePipIndexURL: &schema.Schema{
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				remoteNetworkAttributePipTrustedHost: &schema.Schema{
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				remoteNetworkAttributeCABundle: &schema.Schema{
+					Type:         schema.TypeString,
+					Optional:     true,
+					ValidateFunc: VfPath,
+				},
+			},
+		},
+	}
+}
+
+// NewRemoteNetworkSettingsFromInterface reads remote network configuration from Terraform schema.
+func NewRemoteNetworkSettingsFromInterface(i interface{}, ok bool) *RemoteNetworkSettings {
+	v := &RemoteNetworkSettings{}
+	if !ok {
+		return v
+	}
+	list := i.(*schema.Set).List()
+	if len(list) == 0 {
+		return v
+	}
+	vals := mapFromTypeSetList(list)
+	v.httpProxy = vals[remoteNetworkAttributeHTTPProxy].(string)
+	v.httpsProxy = vals[remoteNetworkAttributeHTTPSProxy].(string)
+	v.noProxy = vals[remoteNetworkAttributeNoProxy].(string)
+	v.pipIndexURL = vals[remoteNetworkAttributePipIndexURL].(string)
+	v.pipTrustedHost = vals[remoteNetworkAttributePipTrustedHost].(string)
+	v.caBundle = vals[remoteNetworkAttributeCABundle].(string)
+	return v
+}
+
+// HTTPProxy returns the proxy for HTTP requests.
+func (v *RemoteNetworkSettings) HTTPProxy() string {
+	return v.httpProxy
+}
+
+// HTTPSProxy returns the proxy for HTTPS requests.
+func (v *RemoteNetworkSettings) HTTPSProxy() string {
+	return v.httpsProxy
+}
+
+// NoProxy returns the comma separated hosts and domains not using the proxy.
+func (v *RemoteNetworkSettings) NoProxy() string {
+	return v.noProxy
+}
+
+// PipIndexURL returns the URL of the Python package index pip uses.
+func (v *RemoteNetworkSettings) PipIndexURL() string {
+	return v.pipIndexURL
+}
+
+// PipTrustedHost returns the hosts pip trusts without valid HTTPS.
+func (v *RemoteNetworkSettings) PipTrustedHost() string {
+	return v.pipTrustedHost
+}
+
+// CABundle returns a path to the local CA bundle uploaded to the remote hosts.
+func (v *RemoteNetworkSettings) CABundle() string {
+	return v.caBundle
+}
+
+// Environment returns the environment variables for the given remote CA bundle path.
+// Proxy variables are given in both lower and upper case, tools differ in which one they read.
+func (v *RemoteNetworkSettings) Environment(remoteCABundle string) map[string]string {
+	env := make(map[string]string)
+	if v.httpProxy != "" {
+		env["http_proxy"] = v.httpProxy
+		env["HTTP_PROXY"] = v.httpProxy
+	}
+	if v.httpsProxy != "" {
+		env["https_proxy"] = v.httpsProxy
+		env["HTTPS_PROXY"] = v.httpsProxy
+	}
+	if v.noProxy != "" {
+		env["no_proxy"] = v.noProxy
+		env["NO_PROXY"] = v.noProxy
+	}
+	if v.pipIndexURL != "" {
+		env["PIP_INDEX_URL"] = v.pipIndexURL
+	}
+	if v.pipTrustedHost != "" {
+		env["PIP_TRUSTED_HOST"] = v.pipTrustedHost
+	}
+	if remoteCABundle != "" {
+		env["SSL_CERT_FILE"] = remoteCABundle
+		env["REQUESTS_CA_BUNDLE"] = remoteCABundle
+		env["PIP_CERT"] = remoteCABundle
+	}
+	return env
+}
@@ -0,0 +1,95 @@
+package types
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// RemotePullSettings represents the git repository the remote provisioner runs playbooks from with ansible-pull.
+type RemotePullSettings struct {
+	repository    string
+	ref           string
+	deployKey     string
+	acceptHostKey bool
+}
+
+const (
+	// attribute names:
+	remotePullAttributeRepository    = "repository"
+	remotePullAttributeRef           = "ref"
+	remotePullAttributeDeployKey     = "deploy_key"
+	remotePullAttributeAcceptHostKey = "accept_host_key"
+)
+
+// NewRemotePullSchema returns a new remote pull schema.
+func NewRemotePullSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeSet,
+		Optional: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				remotePullAttributeRepository: &schema.Schema{
+					Type:     schema.TypeString,
+					Required: true,
+				},
+				remotePullAttributeRef: &schema.Schema{
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				remotePullAttributeDeployKey: &schema.Schema{
+					Type:      schema.TypeString,
+					Optional:  true,
+					Sensitive: true,
+				},
+				remotePullAttributeAcceptHostKey: &schema.Schema{
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  false,
+				},
+			},
+		},
+	}
+}
+
+// NewRemotePullSettingsFromInterface reads remote pull configuration from Terraform schema.
+func NewRemotePullSettingsFromInterface(i interface{}, ok bool) *RemotePullSettings {
+	v := &RemotePullSettings{}
+	if !ok {
+		return v
+	}
+	list := i.(*schema.Set).List()
+	if len(list) == 0 {
+		return v
+	}
+	vals := mapFromTypeSetList(list)
+	v.repository = vals[remotePullAttributeRepository].(string)
+	v.ref = vals[remotePullAttributeRef].(string)
+	v.deployKey = vals[remotePullAttributeDeployKey].(string)
+	v.acceptHostKey = vals[remotePullAttributeAcceptHostKey].(bool)
+	return v
+}
+
+// InUse returns true when playbooks are pulled from a repository.
+func (v *RemotePullSettings) InUse() bool {
+	return v.repository != ""
+}
+
+// Repository returns the URL of the git repository.
+func (v *RemotePullSettings) Repository() string {
+	return v.repository
+}
+
+// Ref returns the branch, tag or commit checked out, empty string means the default branch.
+func (v *RemotePullSettings) Ref() string {
+	return v.ref
+}
+
+// DeployKey returns the private key the repository is cloned with over SSH.
+func (v *RemotePullSettings) DeployKey() string {
+	return v.deployKey
+}
+
+// AcceptHostKey if true, ansible-pull accepts the host key of the git server when not known yet.
+func (v *RemotePullSettings) AcceptHostKey() bool {
+	return v.acceptHostKey
+}
@@ -0,0 +1,12 @@
+package types
+
+// RemotePullArgs is used by the remote provisioner
+// to run a playbook from a git repository with ansible-pull.
+type RemotePullArgs struct {
+	URL            string
+	Checkout       string
+	Directory      string
+	PrivateKeyFile string
+	AcceptHostKey  bool
+	Playbook       string
+}
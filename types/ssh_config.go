@@ -0,0 +1,142 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"text/template"
+)
+
+const sshConfigBastionAlias = "tf-ansible-bastion"
+
+type sshConfigTemplateDataHost struct {
+	User               string
+	Port               int
+	IdentityFile       string
+	UserKnownHostsFile string
+	ForwardAgent       bool
+}
+
+type sshConfigTemplateData struct {
+	BastionAlias        string
+	BastionHostName     string
+	Bastion             sshConfigTemplateDataHost
+	Target              sshConfigTemplateDataHost
+	ConnectTimeout      int
+	ConnectionAttempts  int
+	ServerAliveInterval int
+	ServerAliveCountMax int
+	Include             string
+}
+
+// ssh uses the first obtained value of every option,
+// the user provided ssh_config file is therefore included last:
+const sshConfigTemplate = `# generated by terraform-provisioner-ansible
+{{- if ne .BastionHostName "" }}
+
+Host {{.BastionAlias}}
+  HostName {{.BastionHostName}}
+  User {{.Bastion.User}}
+  Port {{.Bastion.Port}}
+{{- if ne .Bastion.IdentityFile "" }}
+  IdentityFile {{.Bastion.IdentityFile}}
+  IdentitiesOnly yes
+{{- end }}
+{{- if ne .Bastion.UserKnownHostsFile "" }}
+  UserKnownHostsFile {{.Bastion.UserKnownHostsFile}}
+  StrictHostKeyChecking yes
+{{- else }}
+  StrictHostKeyChecking no
+{{- end }}
+  ConnectTimeout {{.ConnectTimeout}}
+{{- if gt .ServerAliveInterval 0 }}
+  ServerAliveInterval {{.ServerAliveInterval}}
+{{- end }}
+{{- if gt .ServerAliveCountMax 0 }}
+  ServerAliveCountMax {{.ServerAliveCountMax}}
+{{- end }}
+
+Host * !{{.BastionAlias}}
+{{- else }}
+
+Host *
+{{- end }}
+  User {{.Target.User}}
+  Port {{.Target.Port}}
+{{- if ne .Target.IdentityFile "" }}
+  IdentityFile {{.Target.IdentityFile}}
+  IdentitiesOnly yes
+{{- end }}
+{{- if ne .Target.UserKnownHostsFile "" }}
+  UserKnownHostsFile {{.Target.UserKnownHostsFile}}
+  StrictHostKeyChecking yes
+{{- else }}
+  StrictHostKeyChecking no
+{{- end }}
+  ConnectTimeout {{.ConnectTimeout}}
+  ConnectionAttempts {{.ConnectionAttempts}}
+{{- if gt .ServerAliveInterval 0 }}
+  ServerAliveInterval {{.ServerAliveInterval}}
+{{- end }}
+{{- if gt .ServerAliveCountMax 0 }}
+  ServerAliveCountMax {{.ServerAliveCountMax}}
+{{- end }}
+{{- if ne .BastionHostName "" }}
+  ProxyJump {{.BastionAlias}}
+{{- if .Target.ForwardAgent }}
+  ForwardAgent yes
+{{- end }}
+{{- end }}
+{{- if ne .Include "" }}
+
+Match all
+  Include {{.Include}}
+{{- end }}
+`
+
+// ToSSHConfig returns the contents of an ssh_config file describing the connection
+// to the target and the bastion, used when generate_ssh_config is enabled.
+func (v *Play) ToSSHConfig(ansibleArgs LocalModeAnsibleArgs, ansibleSSHSettings *AnsibleSSHSettings) (string, error) {
+	templateData := sshConfigTemplateData{
+		BastionAlias:        sshConfigBastionAlias,
+		BastionHostName:     ansibleArgs.BastionHost,
+		ConnectTimeout:      ansibleSSHSettings.ConnectTimeoutSeconds(),
+		ConnectionAttempts:  ansibleSSHSettings.ConnectAttempts(),
+		ServerAliveInterval: ansibleSSHSettings.ServerAliveInterval(),
+		ServerAliveCountMax: ansibleSSHSettings.ServerAliveCountMax(),
+		Include:             ansibleSSHSettings.SSHConfigFile(),
+		Target: sshConfigTemplateDataHost{
+			User:         ansibleArgs.Username,
+			Port:         ansibleArgs.Port,
+			IdentityFile: ansibleArgs.PemFile,
+			ForwardAgent: ansibleArgs.BastionPemFile == "" && os.Getenv("SSH_AUTH_SOCK") != "",
+		},
+		Bastion: sshConfigTemplateDataHost{
+			User:         ansibleArgs.BastionUsername,
+			Port:         ansibleArgs.BastionPort,
+			IdentityFile: ansibleArgs.BastionPemFile,
+		},
+	}
+
+	if !ansibleSSHSettings.InsecureNoStrictHostKeyChecking() {
+		if ansibleSSHSettings.UserKnownHostsFile() != "" {
+			templateData.Target.UserKnownHostsFile = ansibleSSHSettings.UserKnownHostsFile()
+		} else {
+			templateData.Target.UserKnownHostsFile = ansibleArgs.KnownHostsFile
+		}
+	}
+	if !ansibleSSHSettings.InsecureBastionNoStrictHostKeyChecking() {
+		if ansibleSSHSettings.BastionUserKnownHostsFile() != "" {
+			templateData.Bastion.UserKnownHostsFile = ansibleSSHSettings.BastionUserKnownHostsFile()
+		} else {
+			templateData.Bastion.UserKnownHostsFile = ansibleArgs.BastionKnownHostsFile
+		}
+	}
+
+	t := template.Must(template.New("ssh_config").Parse(sshConfigTemplate))
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, templateData); err != nil {
+		return "", fmt.Errorf("Error executing 'ssh_config' template: %s", err)
+	}
+	return buf.String(), nil
+}